@@ -1,27 +1,22 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/echo"
+	"fly-io-dist-sys/internal/health"
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/middleware"
 )
 
 func main() {
 	n := maelstrom.NewNode()
-	n.Handle("echo", func(msg maelstrom.Message) error {
-		// Unmarshal the message body as an loosely-typed map.
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		// Update the message type to return back.
-		body["type"] = "echo_ok"
-
-		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
-	})
+	middleware.Use(n, middleware.Defaults()...)
+	metrics.Handle(n)
+	health.Handle(n)
+	echo.NewServer(n)
 
 	if err := n.Run(); err != nil {
 		log.Fatal(err)
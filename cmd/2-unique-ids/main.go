@@ -1,36 +1,22 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"log"
-	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/health"
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/middleware"
+	"fly-io-dist-sys/internal/uniqueids"
 )
 
 func main() {
-	var (
-		counter     = 0
-		counterLock = sync.Mutex{}
-	)
 	n := maelstrom.NewNode()
-	n.Handle("generate", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		counterLock.Lock()
-		id := fmt.Sprintf("%s-%d", n.ID(), counter)
-		counter++
-		counterLock.Unlock()
-
-		body["type"] = "generate_ok"
-		body["id"] = id
-
-		return n.Reply(msg, body)
-	})
+	middleware.Use(n, middleware.Defaults()...)
+	metrics.Handle(n)
+	health.Handle(n)
+	uniqueids.NewServer(n, uniqueids.DefaultConfig())
 
 	if err := n.Run(); err != nil {
 		log.Fatal(err)
@@ -1,20 +1,24 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"log"
 	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/health"
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/middleware"
+	"fly-io-dist-sys/internal/rpc"
 )
 
-type response struct {
+type readRequest struct {
 	Type string `json:"type"`
 }
 
 type readResponse struct {
-	Type     string `json:"type"`
-	Messages []int  `json:"messages"`
+	Messages []int `json:"messages"`
 }
 
 type topologyRequest struct {
@@ -27,53 +31,54 @@ type broadcastRequest struct {
 }
 
 func main() {
-	var (
-		messages     = make([]int, 0)
-		messagesLock = sync.Mutex{}
-	)
-
 	n := maelstrom.NewNode()
+	middleware.Use(n, middleware.Defaults()...)
+	metrics.Handle(n)
+	health.Handle(n)
 
-	n.Handle("broadcast", func(msg maelstrom.Message) error {
-		var req broadcastRequest
-		if err := json.Unmarshal(msg.Body, &req); err != nil {
-			return err
-		}
+	s := newServer(n)
 
-		messagesLock.Lock()
-		messages = append(messages, req.Message)
-		messagesLock.Unlock()
+	if err := s.n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
 
-		resp := response{
-			Type: "broadcast_ok",
-		}
+type server struct {
+	n            *maelstrom.Node
+	messages     []int
+	messagesLock *sync.Mutex
+}
 
-		return n.Reply(msg, resp)
-	})
+func newServer(n *maelstrom.Node) *server {
+	s := &server{
+		n:            n,
+		messages:     make([]int, 0),
+		messagesLock: &sync.Mutex{},
+	}
 
-	n.Handle("read", func(msg maelstrom.Message) error {
-		resp := readResponse{
-			Type:     "read_ok",
-			Messages: messages,
-		}
+	rpc.Handle(n, "broadcast", s.broadcast)
+	rpc.Handle(n, "read", s.read)
+	rpc.Handle(n, "topology", s.topology)
 
-		return n.Reply(msg, resp)
-	})
+	return s
+}
 
-	n.Handle("topology", func(msg maelstrom.Message) error {
-		var req topologyRequest
-		if err := json.Unmarshal(msg.Body, &req); err != nil {
-			return err
-		}
+func (s *server) broadcast(ctx context.Context, req broadcastRequest) (struct{}, error) {
+	s.messagesLock.Lock()
+	s.messages = append(s.messages, req.Message)
+	s.messagesLock.Unlock()
 
-		resp := response{
-			Type: "topology_ok",
-		}
+	return struct{}{}, nil
+}
 
-		return n.Reply(msg, resp)
-	})
+func (s *server) read(ctx context.Context, req readRequest) (readResponse, error) {
+	s.messagesLock.Lock()
+	msgs := append([]int(nil), s.messages...)
+	s.messagesLock.Unlock()
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
+	return readResponse{Messages: msgs}, nil
+}
+
+func (s *server) topology(ctx context.Context, req topologyRequest) (struct{}, error) {
+	return struct{}{}, nil
 }
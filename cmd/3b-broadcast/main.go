@@ -1,20 +1,24 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"log"
 	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/health"
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/middleware"
+	"fly-io-dist-sys/internal/rpc"
 )
 
-type response struct {
+type readRequest struct {
 	Type string `json:"type"`
 }
 
 type readResponse struct {
-	Type     string `json:"type"`
-	Messages []int  `json:"messages"`
+	Messages []int `json:"messages"`
 }
 
 type topologyRequest struct {
@@ -27,85 +31,84 @@ type broadcastRequest struct {
 }
 
 func main() {
-	var (
-		messages     = make(map[int]struct{})
-		messagesLock = sync.RWMutex{}
+	n := maelstrom.NewNode()
+	middleware.Use(n, middleware.Defaults()...)
+	metrics.Handle(n)
+	health.Handle(n)
 
-		neighbours = []string{}
-	)
+	s := newServer(n)
 
-	n := maelstrom.NewNode()
+	if err := s.n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	n.Handle("broadcast", func(msg maelstrom.Message) error {
-		var req broadcastRequest
-		if err := json.Unmarshal(msg.Body, &req); err != nil {
-			return err
-		}
+type server struct {
+	n            *maelstrom.Node
+	messages     map[int]struct{}
+	messagesLock *sync.RWMutex
+	neighbours   []string
+}
 
-		messagesLock.RLock()
-		_, exists := messages[req.Message]
-		messagesLock.RUnlock()
-
-		if !exists {
-			for _, neighbour := range neighbours {
-				if neighbour == msg.Src {
-					continue
-				}
-				n.RPC(neighbour,
-					broadcastRequest{
-						Type:    "broadcast",
-						Message: req.Message,
-					},
-					func(msg maelstrom.Message) error {
-						return nil
-					})
-			}
-		}
+func newServer(n *maelstrom.Node) *server {
+	s := &server{
+		n:            n,
+		messages:     make(map[int]struct{}),
+		messagesLock: &sync.RWMutex{},
+		neighbours:   []string{},
+	}
 
-		messagesLock.Lock()
-		messages[req.Message] = struct{}{}
-		messagesLock.Unlock()
+	rpc.Handle(n, "broadcast", s.broadcast)
+	rpc.Handle(n, "read", s.read)
+	rpc.Handle(n, "topology", s.topology)
 
-		resp := response{
-			Type: "broadcast_ok",
-		}
+	return s
+}
 
-		return n.Reply(msg, resp)
-	})
+func (s *server) broadcast(ctx context.Context, req broadcastRequest) (struct{}, error) {
+	msg := rpc.Message(ctx)
 
-	n.Handle("read", func(msg maelstrom.Message) error {
-		var msgs []int
+	s.messagesLock.RLock()
+	_, exists := s.messages[req.Message]
+	s.messagesLock.RUnlock()
 
-		messagesLock.RLock()
-		for msg := range messages {
-			msgs = append(msgs, msg)
+	if !exists {
+		for _, neighbour := range s.neighbours {
+			if neighbour == msg.Src {
+				continue
+			}
+			s.n.RPC(neighbour,
+				broadcastRequest{
+					Type:    "broadcast",
+					Message: req.Message,
+				},
+				func(msg maelstrom.Message) error {
+					return nil
+				})
 		}
-		messagesLock.RUnlock()
+	}
 
-		resp := readResponse{
-			Type:     "read_ok",
-			Messages: msgs,
-		}
+	s.messagesLock.Lock()
+	s.messages[req.Message] = struct{}{}
+	s.messagesLock.Unlock()
 
-		return n.Reply(msg, resp)
-	})
+	return struct{}{}, nil
+}
 
-	n.Handle("topology", func(msg maelstrom.Message) error {
-		var req topologyRequest
-		if err := json.Unmarshal(msg.Body, &req); err != nil {
-			return err
-		}
+func (s *server) read(ctx context.Context, req readRequest) (readResponse, error) {
+	msgs := make([]int, 0)
 
-		neighbours = req.Topology[n.ID()]
+	s.messagesLock.RLock()
+	for msg := range s.messages {
+		msgs = append(msgs, msg)
+	}
+	s.messagesLock.RUnlock()
 
-		resp := response{
-			Type: "topology_ok",
-		}
+	return readResponse{Messages: msgs}, nil
+}
 
-		return n.Reply(msg, resp)
-	})
+func (s *server) topology(ctx context.Context, req topologyRequest) (struct{}, error) {
+	s.neighbours = req.Topology[s.n.ID()]
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
+	return struct{}{}, nil
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestBroadcastReachesAllNodes(t *testing.T) {
+	net := sim.NewNetwork(sim.Config{Latency: 5 * time.Millisecond})
+	for _, id := range []string{"n0", "n1", "n2", "n3"} {
+		net.AddNode(id, newServer(maelstrom.NewNode()).n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	c := net.Client("c1")
+
+	// A line, so that messages must be relayed through intermediate nodes.
+	topology := map[string][]string{
+		"n0": {"n1"},
+		"n1": {"n0", "n2"},
+		"n2": {"n1", "n3"},
+		"n3": {"n2"},
+	}
+	for _, id := range net.NodeIDs() {
+		if _, err := c.RPC(ctx, id, map[string]any{"type": "topology", "topology": topology}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, id := range []string{"n0", "n3", "n1"} {
+		if _, err := c.RPC(ctx, id, broadcastRequest{Type: "broadcast", Message: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Give the broadcasts time to make their way down the line.
+	time.Sleep(100 * time.Millisecond)
+
+	for _, id := range net.NodeIDs() {
+		reply, err := c.RPC(ctx, id, map[string]any{"type": "read"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resp readResponse
+		if err := json.Unmarshal(reply.Body, &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		slices.Sort(resp.Messages)
+		if !slices.Equal(resp.Messages, []int{0, 1, 2}) {
+			t.Errorf("%s: expected [0 1 2], got %v", id, resp.Messages)
+		}
+	}
+}
@@ -1,20 +1,25 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"log"
 	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/health"
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/middleware"
+	"fly-io-dist-sys/internal/rpc"
 )
 
 func main() {
-	s := newServer()
+	n := maelstrom.NewNode()
+	middleware.Use(n, middleware.Defaults()...)
+	metrics.Handle(n)
+	health.Handle(n)
 
-	s.n.Handle("send", s.send)
-	s.n.Handle("poll", s.poll)
-	s.n.Handle("commit_offsets", s.commitOffsets)
-	s.n.Handle("list_committed_offsets", s.listCommittedOffsets)
+	s := newServer(n)
 
 	if err := s.n.Run(); err != nil {
 		log.Fatal(err)
@@ -29,23 +34,24 @@ type server struct {
 	committedMu *sync.Mutex
 }
 
-func newServer() server {
-	n := maelstrom.NewNode()
-	return server{
+func newServer(n *maelstrom.Node) *server {
+	s := &server{
 		n:           n,
 		log:         make(map[string][]int),
 		logMu:       &sync.Mutex{},
 		committed:   make(map[string]int),
 		committedMu: &sync.Mutex{},
 	}
-}
 
-func (s *server) send(msg maelstrom.Message) error {
-	var body sendRequest
-	if err := json.Unmarshal(msg.Body, &body); err != nil {
-		return err
-	}
+	rpc.Handle(n, "send", s.send)
+	rpc.Handle(n, "poll", s.poll)
+	rpc.Handle(n, "commit_offsets", s.commitOffsets)
+	rpc.Handle(n, "list_committed_offsets", s.listCommittedOffsets)
+
+	return s
+}
 
+func (s *server) send(ctx context.Context, body sendRequest) (sendResponse, error) {
 	s.logMu.Lock()
 
 	s.log[body.Key] = append(s.log[body.Key], body.Message)
@@ -53,43 +59,28 @@ func (s *server) send(msg maelstrom.Message) error {
 
 	s.logMu.Unlock()
 
-	return s.n.Reply(msg, newSendResponse(offset))
+	return sendResponse{Offset: offset}, nil
 }
 
-func (s *server) poll(msg maelstrom.Message) error {
-	var body pollRequest
-	if err := json.Unmarshal(msg.Body, &body); err != nil {
-		return err
-	}
-
+func (s *server) poll(ctx context.Context, body pollRequest) (pollResponse, error) {
 	s.logMu.Lock()
 	messages := poll(s.log, body.Offsets)
 	s.logMu.Unlock()
 
-	return s.n.Reply(msg, newPollResponse(messages))
+	return pollResponse{Messages: messages}, nil
 }
 
-func (s *server) commitOffsets(msg maelstrom.Message) error {
-	var body commitOffsetsRequest
-	if err := json.Unmarshal(msg.Body, &body); err != nil {
-		return err
-	}
-
+func (s *server) commitOffsets(ctx context.Context, body commitOffsetsRequest) (struct{}, error) {
 	s.committedMu.Lock()
 	for key, offset := range body.Offsets {
 		s.committed[key] = offset
 	}
 	s.committedMu.Unlock()
 
-	return s.n.Reply(msg, newCommitOffsetsResponse())
+	return struct{}{}, nil
 }
 
-func (s *server) listCommittedOffsets(msg maelstrom.Message) error {
-	var body listCommittedOffsetsRequest
-	if err := json.Unmarshal(msg.Body, &body); err != nil {
-		return err
-	}
-
+func (s *server) listCommittedOffsets(ctx context.Context, body listCommittedOffsetsRequest) (listCommittedOffsetsResponse, error) {
 	s.committedMu.Lock()
 
 	committed := make(map[string]int)
@@ -99,7 +90,7 @@ func (s *server) listCommittedOffsets(msg maelstrom.Message) error {
 
 	s.committedMu.Unlock()
 
-	return s.n.Reply(msg, newListCommittedOffsetsResponse(committed))
+	return listCommittedOffsetsResponse{Offsets: committed}, nil
 }
 
 func poll(data map[string][]int, offsets map[string]int) map[string][][]int {
@@ -131,15 +122,7 @@ type sendRequest struct {
 }
 
 type sendResponse struct {
-	Type   string `json:"type"`
-	Offset int    `json:"offset"`
-}
-
-func newSendResponse(offset int) sendResponse {
-	return sendResponse{
-		Type:   "send_ok",
-		Offset: offset,
-	}
+	Offset int `json:"offset"`
 }
 
 type pollRequest struct {
@@ -148,45 +131,19 @@ type pollRequest struct {
 }
 
 type pollResponse struct {
-	Type     string             `json:"type"`
 	Messages map[string][][]int `json:"msgs"`
 }
 
-func newPollResponse(messages map[string][][]int) pollResponse {
-	return pollResponse{
-		Type:     "poll_ok",
-		Messages: messages,
-	}
-}
-
 type commitOffsetsRequest struct {
 	Type    string         `json:"type"`
 	Offsets map[string]int `json:"offsets"`
 }
 
-type commitOffsetsResponse struct {
-	Type string `json:"type"`
-}
-
-func newCommitOffsetsResponse() commitOffsetsResponse {
-	return commitOffsetsResponse{
-		Type: "commit_offsets_ok",
-	}
-}
-
 type listCommittedOffsetsRequest struct {
 	Type string   `json:"type"`
 	Keys []string `json:"keys"`
 }
 
 type listCommittedOffsetsResponse struct {
-	Type    string         `json:"type"`
 	Offsets map[string]int `json:"offsets"`
 }
-
-func newListCommittedOffsetsResponse(offsets map[string]int) listCommittedOffsetsResponse {
-	return listCommittedOffsetsResponse{
-		Type:    "list_committed_offsets_ok",
-		Offsets: offsets,
-	}
-}
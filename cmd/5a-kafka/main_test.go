@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestSendPollCommit(t *testing.T) {
+	net := sim.NewNetwork(sim.Config{Latency: time.Millisecond})
+	net.AddNode("n0", newServer(maelstrom.NewNode()).n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	c := net.Client("c1")
+
+	for i, m := range []int{10, 11, 12} {
+		reply, err := c.RPC(ctx, "n0", sendRequest{Type: "send", Key: "k1", Message: m})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resp sendResponse
+		if err := json.Unmarshal(reply.Body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Offset != i {
+			t.Errorf("expected offset %d, got %d", i, resp.Offset)
+		}
+	}
+
+	reply, err := c.RPC(ctx, "n0", pollRequest{Type: "poll", Offsets: map[string]int{"k1": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var polled pollResponse
+	if err := json.Unmarshal(reply.Body, &polled); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][][]int{"k1": {{1, 11}, {2, 12}}}
+	if !reflect.DeepEqual(polled.Messages, expected) {
+		t.Errorf("expected %v, got %v", expected, polled.Messages)
+	}
+
+	if _, err := c.RPC(ctx, "n0", commitOffsetsRequest{Type: "commit_offsets", Offsets: map[string]int{"k1": 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err = c.RPC(ctx, "n0", listCommittedOffsetsRequest{Type: "list_committed_offsets", Keys: []string{"k1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var committed listCommittedOffsetsResponse
+	if err := json.Unmarshal(reply.Body, &committed); err != nil {
+		t.Fatal(err)
+	}
+	if committed.Offsets["k1"] != 2 {
+		t.Errorf("expected committed offset 2, got %v", committed.Offsets)
+	}
+}
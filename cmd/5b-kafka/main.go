@@ -7,15 +7,20 @@ import (
 	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/health"
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/middleware"
+	"fly-io-dist-sys/internal/rpc"
 )
 
 func main() {
-	s := newServer()
+	n := maelstrom.NewNode()
+	middleware.Use(n, middleware.Defaults()...)
+	metrics.Handle(n)
+	health.Handle(n)
 
-	s.n.Handle("send", s.send)
-	s.n.Handle("poll", s.poll)
-	s.n.Handle("commit_offsets", s.commitOffsets)
-	s.n.Handle("list_committed_offsets", s.listCommittedOffsets)
+	s := newServer(n)
 
 	if err := s.n.Run(); err != nil {
 		log.Fatal(err)
@@ -31,10 +36,9 @@ type server struct {
 	committedMu *sync.Mutex
 }
 
-func newServer() server {
-	n := maelstrom.NewNode()
+func newServer(n *maelstrom.Node) *server {
 	kv := maelstrom.NewLinKV(n)
-	return server{
+	s := &server{
 		n:           n,
 		kv:          kv,
 		log:         make(map[string][]int),
@@ -42,17 +46,19 @@ func newServer() server {
 		committed:   make(map[string]int),
 		committedMu: &sync.Mutex{},
 	}
+
+	rpc.Handle(n, "send", s.send)
+	rpc.Handle(n, "poll", s.poll)
+	rpc.Handle(n, "commit_offsets", s.commitOffsets)
+	rpc.Handle(n, "list_committed_offsets", s.listCommittedOffsets)
+
+	return s
 }
 
-func (s *server) send(msg maelstrom.Message) error {
+func (s *server) send(ctx context.Context, body sendRequest) (sendResponse, error) {
 	s.logMu.Lock()
 	defer s.logMu.Unlock()
 
-	var body sendRequest
-	if err := json.Unmarshal(msg.Body, &body); err != nil {
-		return err
-	}
-
 	if s.isPrimary() {
 		// update local state and write to kv store
 		s.log[body.Key] = append(s.log[body.Key], body.Message)
@@ -65,11 +71,11 @@ func (s *server) send(msg maelstrom.Message) error {
 
 		offset := len(logs) - 1
 
-		if err := s.kv.CompareAndSwap(context.Background(), body.Key, oldLogs, newLogs, true); err != nil {
+		if err := s.kv.CompareAndSwap(ctx, body.Key, oldLogs, newLogs, true); err != nil {
 			log.Println("ERROR send", body.Key, err)
 		}
 
-		return s.n.Reply(msg, sendResponse{Type: "send_ok", Offset: offset})
+		return sendResponse{Offset: offset}, nil
 	} else {
 		// ask primary to update kv store and return response
 		m, err := s.n.SyncRPC(context.TODO(), s.getPrimary(), body)
@@ -77,24 +83,21 @@ func (s *server) send(msg maelstrom.Message) error {
 			log.Println("ERROR send-primary", err)
 		}
 
-		return s.n.Reply(msg, m.Body)
+		var resp sendResponse
+		err = json.Unmarshal(m.Body, &resp)
+		return resp, err
 	}
 }
 
-func (s *server) poll(msg maelstrom.Message) error {
+func (s *server) poll(ctx context.Context, body pollRequest) (pollResponse, error) {
 	s.logMu.Lock()
 	defer s.logMu.Unlock()
 
-	var body pollRequest
-	if err := json.Unmarshal(msg.Body, &body); err != nil {
-		return err
-	}
-
 	polled := make(map[string][][]int)
 
 	for key, offset := range body.Offsets {
 		messages := make([]int, 0)
-		if err := s.kv.ReadInto(context.Background(), key, &messages); err != nil {
+		if err := s.kv.ReadInto(ctx, key, &messages); err != nil {
 			log.Println("ERROR poll", key, err)
 		}
 
@@ -112,24 +115,19 @@ func (s *server) poll(msg maelstrom.Message) error {
 		polled[key] = p
 	}
 
-	return s.n.Reply(msg, pollResponse{Type: "poll_ok", Messages: polled})
+	return pollResponse{Messages: polled}, nil
 }
 
-func (s *server) commitOffsets(msg maelstrom.Message) error {
+func (s *server) commitOffsets(ctx context.Context, body commitOffsetsRequest) (struct{}, error) {
 	s.committedMu.Lock()
 	defer s.committedMu.Unlock()
 
-	var body commitOffsetsRequest
-	if err := json.Unmarshal(msg.Body, &body); err != nil {
-		return err
-	}
-
 	if s.isPrimary() {
 		// update local state and write to kv store
 		for key, offset := range body.Offsets {
 			oldOffset := s.committed[key]
 			s.committed[key] = offset
-			if err := s.kv.CompareAndSwap(context.Background(), "committed-"+key, oldOffset, offset, true); err != nil {
+			if err := s.kv.CompareAndSwap(ctx, "committed-"+key, oldOffset, offset, true); err != nil {
 				log.Println("ERROR commitOffsets", "committed-"+key, err)
 			}
 		}
@@ -138,28 +136,23 @@ func (s *server) commitOffsets(msg maelstrom.Message) error {
 		s.n.SyncRPC(context.TODO(), s.getPrimary(), body)
 	}
 
-	return s.n.Reply(msg, commitOffsetsResponse{Type: "commit_offsets_ok"})
+	return struct{}{}, nil
 }
 
-func (s *server) listCommittedOffsets(msg maelstrom.Message) error {
+func (s *server) listCommittedOffsets(ctx context.Context, body listCommittedOffsetsRequest) (listCommittedOffsetsResponse, error) {
 	s.committedMu.Lock()
 	defer s.committedMu.Unlock()
 
-	var body listCommittedOffsetsRequest
-	if err := json.Unmarshal(msg.Body, &body); err != nil {
-		return err
-	}
-
 	committed := make(map[string]int)
 	for _, key := range body.Keys {
-		offset, err := s.kv.ReadInt(context.Background(), "committed-"+key)
+		offset, err := s.kv.ReadInt(ctx, "committed-"+key)
 		if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
 			log.Println("ERROR listCommittedOffsets", "committed-"+key, err)
 		}
 		committed[key] = offset
 	}
 
-	return s.n.Reply(msg, listCommittedOffsetsResponse{Type: "list_committed_offsets_ok", Offsets: committed})
+	return listCommittedOffsetsResponse{Offsets: committed}, nil
 }
 
 // A simple way to establish a primary node. `n0` is always the primary.
@@ -178,8 +171,7 @@ type sendRequest struct {
 }
 
 type sendResponse struct {
-	Type   string `json:"type"`
-	Offset int    `json:"offset"`
+	Offset int `json:"offset"`
 }
 
 type pollRequest struct {
@@ -188,7 +180,6 @@ type pollRequest struct {
 }
 
 type pollResponse struct {
-	Type     string             `json:"type"`
 	Messages map[string][][]int `json:"msgs"`
 }
 
@@ -197,16 +188,11 @@ type commitOffsetsRequest struct {
 	Offsets map[string]int `json:"offsets"`
 }
 
-type commitOffsetsResponse struct {
-	Type string `json:"type"`
-}
-
 type listCommittedOffsetsRequest struct {
 	Type string   `json:"type"`
 	Keys []string `json:"keys"`
 }
 
 type listCommittedOffsetsResponse struct {
-	Type    string         `json:"type"`
 	Offsets map[string]int `json:"offsets"`
 }
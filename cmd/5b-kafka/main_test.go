@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestSendThroughSecondary(t *testing.T) {
+	net := sim.NewNetwork(sim.Config{Latency: time.Millisecond})
+	net.AddService(maelstrom.LinKV, sim.NewKV(maelstrom.LinKV, sim.KVConfig{}))
+	for _, id := range []string{"n0", "n1"} {
+		net.AddNode(id, newServer(maelstrom.NewNode()).n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	c := net.Client("c1")
+
+	for i, m := range []int{10, 11, 12, 13} {
+		// Alternate between the primary and the secondary.
+		id := net.NodeIDs()[i%2]
+		reply, err := c.RPC(ctx, id, sendRequest{Type: "send", Key: "k1", Message: m})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resp sendResponse
+		if err := json.Unmarshal(reply.Body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Offset != i {
+			t.Errorf("expected offset %d, got %d", i, resp.Offset)
+		}
+	}
+
+	expected := map[string][][]int{"k1": {{2, 12}, {3, 13}}}
+	for _, id := range net.NodeIDs() {
+		reply, err := c.RPC(ctx, id, pollRequest{Type: "poll", Offsets: map[string]int{"k1": 2}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var polled pollResponse
+		if err := json.Unmarshal(reply.Body, &polled); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(polled.Messages, expected) {
+			t.Errorf("%s: expected %v, got %v", id, expected, polled.Messages)
+		}
+	}
+
+	if _, err := c.RPC(ctx, "n1", commitOffsetsRequest{Type: "commit_offsets", Offsets: map[string]int{"k1": 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range net.NodeIDs() {
+		reply, err := c.RPC(ctx, id, listCommittedOffsetsRequest{Type: "list_committed_offsets", Keys: []string{"k1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var committed listCommittedOffsetsResponse
+		if err := json.Unmarshal(reply.Body, &committed); err != nil {
+			t.Fatal(err)
+		}
+		if committed.Offsets["k1"] != 3 {
+			t.Errorf("%s: expected committed offset 3, got %v", id, committed.Offsets)
+		}
+	}
+}
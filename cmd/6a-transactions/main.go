@@ -1,19 +1,25 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
+	"context"
 	"log"
 	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/health"
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/middleware"
+	"fly-io-dist-sys/internal/rpc"
 )
 
 func main() {
-	s := newServer()
+	n := maelstrom.NewNode()
+	middleware.Use(n, middleware.Defaults()...)
+	metrics.Handle(n)
+	health.Handle(n)
 
-	s.n.Handle("txn", s.txn)
+	s := newServer(n)
 
 	if err := s.n.Run(); err != nil {
 		log.Fatal(err)
@@ -26,20 +32,19 @@ type server struct {
 	dataMu *sync.Mutex
 }
 
-func newServer() server {
-	n := maelstrom.NewNode()
-	return server{
+func newServer(n *maelstrom.Node) *server {
+	s := &server{
 		n:      n,
 		data:   make(map[int]*int),
 		dataMu: &sync.Mutex{},
 	}
+
+	rpc.Handle(n, "txn", s.txn)
+
+	return s
 }
 
-func (s *server) txn(msg maelstrom.Message) error {
-	var body txnRequest
-	if err := json.Unmarshal(msg.Body, &body); err != nil {
-		return err
-	}
+func (s *server) txn(ctx context.Context, body txnRequest) (txnResponse, error) {
 
 	txn := make([]operation, 0)
 
@@ -53,7 +58,7 @@ func (s *server) txn(msg maelstrom.Message) error {
 		case write:
 			s.data[op.key] = op.value
 		default:
-			return errors.New(fmt.Sprintf("Unrecognized operation: %v", op.operationType))
+			return txnResponse{}, rpc.Errorf(maelstrom.MalformedRequest, "unrecognized operation: %v", op.operationType)
 		}
 
 		txn = append(txn, op)
@@ -61,5 +66,5 @@ func (s *server) txn(msg maelstrom.Message) error {
 
 	s.dataMu.Unlock()
 
-	return s.n.Reply(msg, txnResponse{Type: "txn_ok", Transaction: txn})
+	return txnResponse{Transaction: txn}, nil
 }
@@ -19,7 +19,6 @@ type txnRequest struct {
 }
 
 type txnResponse struct {
-	Type        string      `json:"type"`
 	Transaction []operation `json:"txn"`
 }
 
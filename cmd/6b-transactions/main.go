@@ -1,21 +1,27 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
+	"context"
 	"log"
 	"strings"
 	"sync"
-	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/health"
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/middleware"
+	"fly-io-dist-sys/internal/retry"
+	"fly-io-dist-sys/internal/rpc"
 )
 
 func main() {
-	s := newServer()
+	n := maelstrom.NewNode()
+	middleware.Use(n, middleware.Defaults()...)
+	metrics.Handle(n)
+	health.Handle(n)
 
-	s.n.Handle("txn", s.txn)
+	s := newServer(n)
 
 	if err := s.n.Run(); err != nil {
 		log.Fatal(err)
@@ -24,24 +30,26 @@ func main() {
 
 type server struct {
 	n      *maelstrom.Node
+	sender *retry.Sender
 	data   map[int]*int
 	dataMu *sync.Mutex
 }
 
-func newServer() server {
-	n := maelstrom.NewNode()
-	return server{
+func newServer(n *maelstrom.Node) *server {
+	s := &server{
 		n:      n,
+		sender: retry.NewSender(n, retry.DefaultConfig()),
 		data:   make(map[int]*int),
 		dataMu: &sync.Mutex{},
 	}
+
+	rpc.Handle(n, "txn", s.txn)
+
+	return s
 }
 
-func (s *server) txn(msg maelstrom.Message) error {
-	var body txnRequest
-	if err := json.Unmarshal(msg.Body, &body); err != nil {
-		return err
-	}
+func (s *server) txn(ctx context.Context, body txnRequest) (txnResponse, error) {
+	msg := rpc.Message(ctx)
 
 	txn := make([]operation, 0)
 
@@ -55,7 +63,7 @@ func (s *server) txn(msg maelstrom.Message) error {
 		case write:
 			s.data[op.key] = op.value
 		default:
-			return errors.New(fmt.Sprintf("Unrecognized operation: %v", op.operationType))
+			return txnResponse{}, rpc.Errorf(maelstrom.MalformedRequest, "unrecognized operation: %v", op.operationType)
 		}
 
 		txn = append(txn, op)
@@ -71,19 +79,9 @@ func (s *server) txn(msg maelstrom.Message) error {
 				continue
 			}
 
-			// retry in a separate goroutine
-			go func(nId string) {
-				sent := false
-				for !sent {
-					s.n.RPC(nId, body, func(msg maelstrom.Message) error {
-						sent = true
-						return nil
-					})
-					time.Sleep(2 * time.Second)
-				}
-			}(nId)
+			s.sender.Send(context.Background(), nId, body, nil)
 		}
 	}
 
-	return s.n.Reply(msg, txnResponse{Type: "txn_ok", Transaction: txn})
+	return txnResponse{Transaction: txn}, nil
 }
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// keyword is an EDN keyword, without its leading colon.
+type keyword string
+
+// symbol is an EDN symbol.
+type symbol string
+
+// tagged is an EDN tagged literal, such as a record or #inst.
+type tagged struct {
+	tag   symbol
+	value any
+}
+
+// set is an EDN set. Sets only appear as values in maelstrom results, so
+// their elements are kept in order rather than deduplicated.
+type set []any
+
+// parseEDN parses a single EDN value.
+//
+// Maps are parsed into map[any]any. Keys which cannot be used as Go map keys,
+// such as vectors, are replaced by their printed form.
+func parseEDN(r io.Reader) (any, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ednParser{src: []rune(string(buf))}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skip()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q after value", p.src[p.pos])
+	}
+	return v, nil
+}
+
+type ednParser struct {
+	src []rune
+	pos int
+}
+
+var errEOF = errors.New("unexpected end of input")
+
+func (p *ednParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("edn: offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// skip advances past whitespace, commas and comments.
+func (p *ednParser) skip() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ',' || unicode.IsSpace(c):
+			p.pos++
+		case c == ';':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *ednParser) value() (any, error) {
+	for {
+		p.skip()
+		if p.pos >= len(p.src) {
+			return nil, errEOF
+		}
+
+		c := p.src[p.pos]
+		switch {
+		case c == '{':
+			p.pos++
+			return p.mapValue()
+		case c == '[' || c == '(':
+			p.pos++
+			return p.sequence(closing(c))
+		case c == '"':
+			p.pos++
+			return p.stringValue()
+		case c == ':':
+			p.pos++
+			return keyword(p.token()), nil
+		case c == '\\':
+			p.pos++
+			return p.character()
+		case c == '#':
+			p.pos++
+			if p.pos >= len(p.src) {
+				return nil, errEOF
+			}
+			switch p.src[p.pos] {
+			case '{':
+				p.pos++
+				items, err := p.sequence('}')
+				return set(items), err
+			case '_':
+				// Discard the next value and carry on.
+				p.pos++
+				if _, err := p.value(); err != nil {
+					return nil, err
+				}
+				continue
+			default:
+				tag := symbol(p.token())
+				v, err := p.value()
+				return tagged{tag: tag, value: v}, err
+			}
+		case c == '}' || c == ']' || c == ')':
+			return nil, p.errorf("unexpected %q", c)
+		default:
+			return p.atom(p.token())
+		}
+	}
+}
+
+func closing(open rune) rune {
+	if open == '(' {
+		return ')'
+	}
+	return ']'
+}
+
+// sequence parses the elements of a list, vector or set up to the closing
+// delimiter.
+func (p *ednParser) sequence(end rune) ([]any, error) {
+	items := make([]any, 0)
+	for {
+		p.skip()
+		if p.pos >= len(p.src) {
+			return nil, errEOF
+		}
+		if p.src[p.pos] == end {
+			p.pos++
+			return items, nil
+		}
+
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+}
+
+func (p *ednParser) mapValue() (map[any]any, error) {
+	items, err := p.sequence('}')
+	if err != nil {
+		return nil, err
+	}
+	if len(items)%2 != 0 {
+		return nil, p.errorf("map has an odd number of forms")
+	}
+
+	m := make(map[any]any, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		m[mapKey(items[i])] = items[i+1]
+	}
+	return m, nil
+}
+
+// mapKey returns k if it can be used as a Go map key, or its printed form
+// otherwise.
+func mapKey(k any) any {
+	switch k.(type) {
+	case nil, bool, int64, float64, string, keyword, symbol:
+		return k
+	default:
+		return fmt.Sprint(k)
+	}
+}
+
+func (p *ednParser) stringValue() (string, error) {
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+
+		switch c {
+		case '"':
+			return sb.String(), nil
+		case '\\':
+			if p.pos >= len(p.src) {
+				return "", errEOF
+			}
+			esc := p.src[p.pos]
+			p.pos++
+			switch esc {
+			case 'n':
+				sb.WriteRune('\n')
+			case 't':
+				sb.WriteRune('\t')
+			case 'r':
+				sb.WriteRune('\r')
+			case 'u':
+				if p.pos+4 > len(p.src) {
+					return "", errEOF
+				}
+				r, err := strconv.ParseUint(string(p.src[p.pos:p.pos+4]), 16, 32)
+				if err != nil {
+					return "", p.errorf("bad unicode escape: %v", err)
+				}
+				sb.WriteRune(rune(r))
+				p.pos += 4
+			default:
+				sb.WriteRune(esc)
+			}
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	return "", errEOF
+}
+
+func (p *ednParser) character() (string, error) {
+	// A character is a single rune, or a name such as \newline.
+	if p.pos >= len(p.src) {
+		return "", errEOF
+	}
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.src) && !isDelimiter(p.src[p.pos]) {
+		p.pos++
+	}
+
+	switch name := string(p.src[start:p.pos]); name {
+	case "newline":
+		return "\n", nil
+	case "space":
+		return " ", nil
+	case "tab":
+		return "\t", nil
+	case "return":
+		return "\r", nil
+	default:
+		return name, nil
+	}
+}
+
+// token returns the run of characters up to the next delimiter.
+func (p *ednParser) token() string {
+	start := p.pos
+	for p.pos < len(p.src) && !isDelimiter(p.src[p.pos]) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+func isDelimiter(c rune) bool {
+	return unicode.IsSpace(c) || strings.ContainsRune(",;\"{}[]()#", c)
+}
+
+// atom parses a number, nil, boolean or symbol.
+func (p *ednParser) atom(tok string) (any, error) {
+	switch tok {
+	case "":
+		return nil, p.errorf("empty token")
+	case "nil":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	first := tok[0]
+	if (first >= '0' && first <= '9') || ((first == '-' || first == '+') && len(tok) > 1 && tok[1] >= '0' && tok[1] <= '9') {
+		return p.number(tok)
+	}
+
+	return symbol(tok), nil
+}
+
+// number parses integers, floats and ratios. Arbitrary precision suffixes
+// are accepted, but values are converted to int64 or float64.
+func (p *ednParser) number(tok string) (any, error) {
+	tok = strings.TrimPrefix(tok, "+")
+
+	if num, den, ok := strings.Cut(tok, "/"); ok {
+		r, ok := new(big.Rat).SetString(num + "/" + den)
+		if !ok {
+			return nil, p.errorf("bad ratio %q", tok)
+		}
+		f, _ := r.Float64()
+		return f, nil
+	}
+
+	if strings.HasSuffix(tok, "M") {
+		tok = strings.TrimSuffix(tok, "M")
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %q", tok)
+		}
+		return f, nil
+	}
+
+	tok = strings.TrimSuffix(tok, "N")
+	if i, err := strconv.ParseInt(tok, 10, 64); err == nil {
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(tok, 64)
+	if err != nil {
+		return nil, p.errorf("bad number %q", tok)
+	}
+	return f, nil
+}
+
+// get follows a path of keys through nested maps. Numeric keys match map
+// keys with the same numeric value, so 1 finds both 1 and 1.0.
+func get(v any, path ...any) (any, bool) {
+	for _, k := range path {
+		m, ok := v.(map[any]any)
+		if !ok {
+			return nil, false
+		}
+
+		v, ok = m[k]
+		if ok {
+			continue
+		}
+
+		want, isNum := toFloat(k)
+		if !isNum {
+			return nil, false
+		}
+		found := false
+		for mk, mv := range m {
+			if f, isNum := toFloat(mk); isNum && f == want {
+				v, found = mv, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,206 @@
+// Command analyze checks a maelstrom results.edn against the performance
+// targets of a challenge.
+//
+// Usage:
+//
+//	analyze -challenge 3e [store/latest/results.edn]
+//
+// A table of metrics is printed, and the exit status is non-zero if any of
+// them misses its target. Targets can be overridden with flags.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// thresholds are the targets a run must meet. Zero means no target.
+type thresholds struct {
+	msgsPerOp     float64
+	medianLatency float64
+	maxLatency    float64
+
+	// availability is the lowest fraction of operations which must succeed.
+	availability float64
+}
+
+// challengeThresholds are the targets set by each challenge, or by the way
+// the justfile runs it. Every challenge must also produce a valid result.
+//
+// Stable latencies are only reported by the broadcast workload, so the other
+// challenges can only be held to their availability.
+var challengeThresholds = map[string]thresholds{
+	// echo has nothing to measure beyond the replies being correct.
+	"1": {},
+	// run with --availability total, so every generate must succeed
+	"2": {availability: 1},
+	// There is no network latency, so a message should be stable on every
+	// node well within a second. Anything slower was stuck in a retry.
+	"3a": {maxLatency: 1000},
+	"3b": {maxLatency: 1000},
+	// Messages sent during a partition are only stable once it heals, which
+	// the partition nemesis does every 10 seconds. Anything slower missed a
+	// heal, and was only caught up by a later one.
+	"3c": {maxLatency: 15000},
+	"3d": {msgsPerOp: 30, medianLatency: 400, maxLatency: 600},
+	"3e": {msgsPerOp: 20, medianLatency: 1000, maxLatency: 2000},
+	// The counter is only checked by its final reads, which the workload
+	// folds into :valid?, and may be briefly unavailable during a partition.
+	"4": {},
+	// The kafka challenges set no performance targets, and the workload
+	// reports no latencies. Sends and polls may fail while a node catches up.
+	"5a": {},
+	"5b": {},
+	"5c": {},
+	// run with --availability total, so every transaction must succeed
+	"6a": {availability: 1},
+	"6b": {availability: 1},
+	"6c": {availability: 1},
+}
+
+func main() {
+	log.SetFlags(0)
+
+	challenge := flag.String("challenge", "", "challenge whose targets to check, e.g. 3e")
+	msgsPerOp := flag.Float64("max-msgs-per-op", 0, "override the messages per operation target")
+	medianLatency := flag.Float64("max-median-latency", 0, "override the median stable latency target, in ms")
+	maxLatency := flag.Float64("max-latency", 0, "override the maximum stable latency target, in ms")
+	availability := flag.Float64("min-availability", 0, "override the fraction of operations which must succeed")
+	flag.Parse()
+
+	t, ok := challengeThresholds[*challenge]
+	if !ok {
+		log.Fatalf("unknown challenge %q, expected one of %s", *challenge, strings.Join(challenges(), ", "))
+	}
+	if *msgsPerOp > 0 {
+		t.msgsPerOp = *msgsPerOp
+	}
+	if *medianLatency > 0 {
+		t.medianLatency = *medianLatency
+	}
+	if *maxLatency > 0 {
+		t.maxLatency = *maxLatency
+	}
+	if *availability > 0 {
+		t.availability = *availability
+	}
+
+	var in io.Reader = os.Stdin
+	if path := flag.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	results, err := parseEDN(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	checks := evaluate(results, t)
+	printChecks(os.Stdout, checks)
+
+	for _, c := range checks {
+		if !c.ok {
+			os.Exit(1)
+		}
+	}
+}
+
+// check is a single metric compared against its target.
+type check struct {
+	name   string
+	value  string
+	target string
+	ok     bool
+}
+
+func evaluate(results any, t thresholds) []check {
+	checks := make([]check, 0)
+
+	valid, _ := get(results, keyword("valid?"))
+	checks = append(checks, check{
+		name:   "valid",
+		value:  fmt.Sprint(valid),
+		target: "true",
+		ok:     valid == true,
+	})
+
+	metrics := []struct {
+		name   string
+		path   []any
+		target float64
+		unit   string
+	}{
+		{"messages per operation", []any{keyword("net"), keyword("servers"), keyword("msgs-per-op")}, t.msgsPerOp, ""},
+		{"median latency", []any{keyword("workload"), keyword("stable-latencies"), 0.5}, t.medianLatency, "ms"},
+		{"maximum latency", []any{keyword("workload"), keyword("stable-latencies"), 1}, t.maxLatency, "ms"},
+	}
+
+	for _, m := range metrics {
+		raw, found := get(results, m.path...)
+		value, isNum := toFloat(raw)
+
+		c := check{name: m.name, value: "-", target: "-", ok: true}
+		if found && isNum {
+			c.value = fmt.Sprintf("%.2f%s", value, m.unit)
+		}
+		if m.target > 0 {
+			c.target = fmt.Sprintf("< %g%s", m.target, m.unit)
+			c.ok = found && isNum && value < m.target
+		}
+
+		checks = append(checks, c)
+	}
+
+	// maelstrom counts every completed operation, and how many of them
+	// succeeded
+	count, foundCount := get(results, keyword("stats"), keyword("count"))
+	okCount, foundOK := get(results, keyword("stats"), keyword("ok-count"))
+	total, isNum := toFloat(count)
+	succeeded, isNumOK := toFloat(okCount)
+
+	c := check{name: "availability", value: "-", target: "-", ok: true}
+	found := foundCount && foundOK && isNum && isNumOK && total > 0
+	if found {
+		c.value = fmt.Sprintf("%.4f", succeeded/total)
+	}
+	if t.availability > 0 {
+		c.target = fmt.Sprintf(">= %g", t.availability)
+		c.ok = found && succeeded/total >= t.availability
+	}
+	checks = append(checks, c)
+
+	return checks
+}
+
+func printChecks(w io.Writer, checks []check) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METRIC\tVALUE\tTARGET\tRESULT")
+	for _, c := range checks {
+		result := "pass"
+		if !c.ok {
+			result = "FAIL"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.name, c.value, c.target, result)
+	}
+	tw.Flush()
+}
+
+func challenges() []string {
+	names := make([]string, 0, len(challengeThresholds))
+	for name := range challengeThresholds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// An abridged results.edn from a 3e run.
+const results = `{:perf {:latency-graph {:valid? true},
+        :rate-graph {:valid? true},
+        :valid? true},
+ :timeline {:valid? true},
+ :exceptions {:valid? true},
+ :stats {:valid? true,
+         :count 2004,
+         :ok-count 2004,
+         :by-f {:broadcast {:valid? true, :count 1003}}},
+ :net {:all {:send-count 30252, :recv-count 30252, :msg-count 30252, :msgs-per-op 15.095808},
+       :clients {:send-count 4108, :recv-count 4108, :msg-count 4108},
+       :servers {:send-count 26144, :recv-count 26144, :msg-count 26144, :msgs-per-op 13.045908},
+       :valid? true},
+ :workload {:worst-stale (),
+            :duplicated-count 0,
+            :valid? true,
+            :lost-count 0,
+            :lost #{},
+            :stable-count 1003,
+            :stable-latencies {0 0, 0.5 853, 0.95 1323, 0.99 1389, 1 1411},
+            :attempt-count 1003,
+            :never-read (),
+            :never-read-count 0,
+            :duplicated {}},
+ :valid? true}
+`
+
+func TestEvaluate(t *testing.T) {
+	v, err := parseEDN(strings.NewReader(results))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := evaluate(v, challengeThresholds["3e"])
+	expected := []check{
+		{name: "valid", value: "true", target: "true", ok: true},
+		{name: "messages per operation", value: "13.05", target: "< 20", ok: true},
+		{name: "median latency", value: "853.00ms", target: "< 1000ms", ok: true},
+		{name: "maximum latency", value: "1411.00ms", target: "< 2000ms", ok: true},
+		{name: "availability", value: "1.0000", target: "-", ok: true},
+	}
+	if !reflect.DeepEqual(checks, expected) {
+		t.Fatalf("expected %v, got %v", expected, checks)
+	}
+
+	// Batching keeps messages low, but is too slow for 3d.
+	checks = evaluate(v, challengeThresholds["3d"])
+	for i, ok := range []bool{true, true, false, false} {
+		if checks[i].ok != ok {
+			t.Errorf("%s: expected ok to be %v against the 3d targets", checks[i].name, ok)
+		}
+	}
+}
+
+func TestEvaluateMissingMetrics(t *testing.T) {
+	v, err := parseEDN(strings.NewReader(`{:valid? false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := evaluate(v, thresholds{msgsPerOp: 20})
+	if checks[0].ok {
+		t.Error("expected invalid result to fail")
+	}
+	if checks[1].ok {
+		t.Error("expected missing metric with a target to fail")
+	}
+	if !checks[2].ok {
+		t.Error("expected missing metric without a target to pass")
+	}
+}
+
+func TestEvaluateAvailability(t *testing.T) {
+	v, err := parseEDN(strings.NewReader(`{:valid? true, :stats {:count 200, :ok-count 199}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := evaluate(v, challengeThresholds["6b"])
+	availability := checks[len(checks)-1]
+	if availability.value != "0.9950" || availability.ok {
+		t.Errorf("expected one failed transaction to miss total availability, got %+v", availability)
+	}
+
+	// the kafka challenges tolerate failed operations
+	checks = evaluate(v, challengeThresholds["5b"])
+	if availability := checks[len(checks)-1]; !availability.ok {
+		t.Errorf("expected no availability target, got %+v", availability)
+	}
+}
+
+func TestParseEDN(t *testing.T) {
+	src := `{:a [1 -2 +3 4N 1.5 2.5M 1/4 nil true false]
+	         :b "str\"ing\n" ; a comment
+	         :c #{:x :y}
+	         :d #jepsen.history.Op{:index 0, :type :invoke}
+	         :e (sym \a \newline)
+	         #_ :ignored #_ 1
+	         [1 2] :vector-key}`
+
+	v, err := parseEDN(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[any]any{
+		keyword("a"): []any{int64(1), int64(-2), int64(3), int64(4), 1.5, 2.5, 0.25, nil, true, false},
+		keyword("b"): "str\"ing\n",
+		keyword("c"): set{keyword("x"), keyword("y")},
+		keyword("d"): tagged{
+			tag:   "jepsen.history.Op",
+			value: map[any]any{keyword("index"): int64(0), keyword("type"): keyword("invoke")},
+		},
+		keyword("e"): []any{symbol("sym"), "a", "\n"},
+		"[1 2]":      keyword("vector-key"),
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatalf("expected %v, got %v", expected, v)
+	}
+}
+
+func TestParseEDNErrors(t *testing.T) {
+	for _, src := range []string{`{:a`, `{:a 1 :b}`, `[1 2))`, `"unterminated`, `1 2`} {
+		if _, err := parseEDN(strings.NewReader(src)); err == nil {
+			t.Errorf("expected error parsing %q", src)
+		}
+	}
+}
+
+func TestGetNumericKeys(t *testing.T) {
+	v := map[any]any{int64(1): "int", 0.5: "float"}
+
+	if got, _ := get(v, 1); got != "int" {
+		t.Errorf("expected int, got %v", got)
+	}
+	if got, _ := get(v, 1.0); got != "int" {
+		t.Errorf("expected int, got %v", got)
+	}
+	if got, _ := get(v, 0.5); got != "float" {
+		t.Errorf("expected float, got %v", got)
+	}
+	if _, ok := get(v, 2); ok {
+		t.Error("expected missing key")
+	}
+}
@@ -0,0 +1,152 @@
+// Command gloomers runs a node for any of the challenges, selected by
+// subcommand. Tuning parameters are passed as flags, so they can be changed
+// without recompiling.
+//
+// Usage:
+//
+//	gloomers echo
+//	gloomers unique-ids [--mode counter|snowflake|ulid|uuidv7|dense] [--state-dir dir]
+//	                    [--block-size 1000] [--max-batch-size 10000] [--max-clock-rewind 1s]
+//	                    [--lease-size 100] [--lease-timeout 1s]
+//	gloomers broadcast [--batch-interval 700ms] [--max-batch-size 100] [--target-batch-size 2]
+//	                   [--idle-timeout 500ms] [--overlay tree|topology|plumtree|gossip]
+//	                   [--fanout 4] [--lazy-fanout 2] [--graft-timeout 1s]
+//	                   [--gossip-fanout 3] [--gossip-interval 200ms]
+//	                   [--suspect-timeout 2s] [--suspect-after 2]
+//	                   [--delivery-timeout 5s] [--anti-entropy-interval 1s]
+//	gloomers counter
+//	gloomers kafka [--primary n0] [--forward-timeout 2s] [--kv-timeout 1s]
+//	gloomers txn [--abort-rate 0]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/broadcast"
+	"fly-io-dist-sys/internal/counter"
+	"fly-io-dist-sys/internal/echo"
+	"fly-io-dist-sys/internal/health"
+	"fly-io-dist-sys/internal/kafka"
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/middleware"
+	"fly-io-dist-sys/internal/txn"
+	"fly-io-dist-sys/internal/uniqueids"
+)
+
+// A command registers its flags on fs, and returns a function which sets up
+// the node once the flags have been parsed.
+type command func(fs *flag.FlagSet) func(n *maelstrom.Node)
+
+var commands = map[string]command{
+	"echo": func(fs *flag.FlagSet) func(n *maelstrom.Node) {
+		return func(n *maelstrom.Node) { echo.NewServer(n) }
+	},
+
+	"unique-ids": func(fs *flag.FlagSet) func(n *maelstrom.Node) {
+		cfg := uniqueids.DefaultConfig()
+		fs.Func("mode", `kind of ID to generate: "counter", "snowflake", "ulid", "uuidv7" or "dense"`, func(v string) error {
+			switch m := uniqueids.Mode(v); m {
+			case uniqueids.ModeCounter, uniqueids.ModeSnowflake, uniqueids.ModeULID, uniqueids.ModeUUIDv7, uniqueids.ModeDense:
+				cfg.Mode = m
+				return nil
+			default:
+				return fmt.Errorf("unknown mode %q", v)
+			}
+		})
+		fs.StringVar(&cfg.StateDir, "state-dir", cfg.StateDir, "directory where counter IDs are reserved, so they survive a restart")
+		fs.IntVar(&cfg.BlockSize, "block-size", cfg.BlockSize, "how many counter IDs to reserve at a time")
+		fs.IntVar(&cfg.MaxBatchSize, "max-batch-size", cfg.MaxBatchSize, "most IDs a single batch or range request may ask for")
+		fs.IntVar(&cfg.LeaseSize, "lease-size", cfg.LeaseSize, "how many dense IDs to lease from lin-kv at a time")
+		fs.DurationVar(&cfg.LeaseTimeout, "lease-timeout", cfg.LeaseTimeout, "how long to wait for lin-kv when leasing dense IDs")
+		fs.DurationVar(&cfg.MaxClockRewind, "max-clock-rewind", cfg.MaxClockRewind, "how far the clock may go backwards before snowflake IDs are refused")
+		return func(n *maelstrom.Node) { uniqueids.NewServer(n, cfg) }
+	},
+
+	"broadcast": func(fs *flag.FlagSet) func(n *maelstrom.Node) {
+		cfg := broadcast.DefaultConfig()
+		fs.DurationVar(&cfg.BatchInterval, "batch-interval", cfg.BatchInterval, "longest a new message waits before it is sent on to neighbours, which bounds latency")
+		fs.IntVar(&cfg.MaxBatchSize, "max-batch-size", cfg.MaxBatchSize, "how many new messages to send on at once without waiting, 0 for no limit")
+		fs.IntVar(&cfg.TargetBatchSize, "target-batch-size", cfg.TargetBatchSize, "how many messages a batch waits to collect under heavy load, which bounds messages per operation, 1 to turn batching off")
+		fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout, "how long a batch waits for another message before it is sent, 0 to turn off")
+		fs.Func("overlay", `graph to broadcast over: "tree" built from the node IDs, the "topology" maelstrom supplies, a self-repairing "plumtree", or "gossip" with random peers`, func(v string) error {
+			switch o := broadcast.Overlay(v); o {
+			case broadcast.OverlayTree, broadcast.OverlayTopology, broadcast.OverlayPlumtree, broadcast.OverlayGossip:
+				cfg.Overlay = o
+				return nil
+			default:
+				return fmt.Errorf("unknown overlay %q", v)
+			}
+		})
+		fs.IntVar(&cfg.Fanout, "fanout", cfg.Fanout, "how many children each node has in the tree overlay")
+		fs.IntVar(&cfg.LazyFanout, "lazy-fanout", cfg.LazyFanout, "how many peers outside the tree a plumtree node announces messages to")
+		fs.DurationVar(&cfg.GraftTimeout, "graft-timeout", cfg.GraftTimeout, "how long a plumtree node waits for an announced message before grafting its announcer")
+		fs.IntVar(&cfg.GossipFanout, "gossip-fanout", cfg.GossipFanout, "how many random peers a gossip node exchanges messages with each round")
+		fs.DurationVar(&cfg.GossipInterval, "gossip-interval", cfg.GossipInterval, "how often a gossip node starts a round")
+		fs.DurationVar(&cfg.SuspectTimeout, "suspect-timeout", cfg.SuspectTimeout, "how long a neighbour may take to acknowledge a batch, 0 to never route around it")
+		fs.IntVar(&cfg.SuspectAfter, "suspect-after", cfg.SuspectAfter, "how many timeouts in a row before batches are routed around a neighbour")
+		fs.DurationVar(&cfg.DeliveryTimeout, "delivery-timeout", cfg.DeliveryTimeout, "how long to retry a batch before merging it with newer messages for the same neighbour, 0 to retry until acknowledged")
+		fs.DurationVar(&cfg.AntiEntropyInterval, "anti-entropy-interval", cfg.AntiEntropyInterval, "how often to reconcile messages with a neighbour, 0 to turn off")
+		return func(n *maelstrom.Node) { broadcast.NewServer(n, cfg) }
+	},
+
+	"counter": func(fs *flag.FlagSet) func(n *maelstrom.Node) {
+		return func(n *maelstrom.Node) { counter.NewServer(n) }
+	},
+
+	"kafka": func(fs *flag.FlagSet) func(n *maelstrom.Node) {
+		cfg := kafka.DefaultConfig()
+		fs.StringVar(&cfg.Primary, "primary", cfg.Primary, "ID of the node which performs all writes")
+		fs.DurationVar(&cfg.ForwardTimeout, "forward-timeout", cfg.ForwardTimeout, "how long to wait for the primary to acknowledge a forwarded write")
+		fs.DurationVar(&cfg.KVTimeout, "kv-timeout", cfg.KVTimeout, "how long the primary waits for the KV stores, shorter than --forward-timeout")
+		return func(n *maelstrom.Node) { kafka.NewServer(n, cfg) }
+	},
+
+	"txn": func(fs *flag.FlagSet) func(n *maelstrom.Node) {
+		cfg := txn.DefaultConfig()
+		fs.Float64Var(&cfg.AbortRate, "abort-rate", cfg.AbortRate, "fraction of client transactions to abort")
+		return func(n *maelstrom.Node) { txn.NewServer(n, cfg) }
+	},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("gloomers "+os.Args[1], flag.ExitOnError)
+	setup := cmd(fs)
+	fs.Parse(os.Args[2:])
+
+	n := maelstrom.NewNode()
+	middleware.Use(n, middleware.Defaults()...)
+	metrics.Handle(n)
+	health.Handle(n)
+	setup(n)
+
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage: gloomers <%s> [flags]\n", strings.Join(names, "|"))
+	os.Exit(2)
+}
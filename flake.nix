@@ -26,7 +26,6 @@
           just
           go
           gotools
-          jet
           maelstrom-clj
           self.packages.${system}.default
         ];
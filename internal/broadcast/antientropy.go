@@ -0,0 +1,174 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"time"
+)
+
+// digestBuckets is how many buckets a digest splits the messages into. Only
+// the messages in buckets which differ are exchanged, so more buckets mean
+// smaller transfers but a larger digest.
+const digestBuckets = 64
+
+// digest summarises a set of messages, so that two nodes can find out which
+// messages they disagree on without sending them all.
+//
+// Messages are hashed into buckets, and each bucket records how many messages
+// it holds and the sum of their hashes. Two sets with the same messages in a
+// bucket always agree on it, and sets which differ almost never do.
+//
+// Sums are sent as strings: maelstrom decodes message bodies into float64s,
+// which would round them, and equal buckets would no longer compare equal.
+type digest []bucket
+
+type bucket struct {
+	Count int    `json:"count"`
+	Sum   uint64 `json:"sum,string"`
+}
+
+func hashMessage(msg int) uint64 {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(msg))
+
+	h := fnv.New64a()
+	h.Write(buf[:])
+	return h.Sum64()
+}
+
+func bucketOf(msg int) int {
+	return int(hashMessage(msg) % digestBuckets)
+}
+
+func newDigest(msgs []int) digest {
+	d := make(digest, digestBuckets)
+	for _, msg := range msgs {
+		h := hashMessage(msg)
+		b := &d[h%digestBuckets]
+		b.Count++
+		b.Sum += h
+	}
+	return d
+}
+
+// diff returns the buckets in which d and other differ.
+func (d digest) diff(other digest) []int {
+	buckets := make([]int, 0)
+	for i := range d {
+		if i >= len(other) || d[i] != other[i] {
+			buckets = append(buckets, i)
+		}
+	}
+	return buckets
+}
+
+// inBuckets returns the messages which fall into any of the given buckets.
+func inBuckets(msgs []int, buckets []int) []int {
+	var want [digestBuckets]bool
+	for _, b := range buckets {
+		if b >= 0 && b < digestBuckets {
+			want[b] = true
+		}
+	}
+
+	in := make([]int, 0)
+	for _, msg := range msgs {
+		if want[bucketOf(msg)] {
+			in = append(in, msg)
+		}
+	}
+	return in
+}
+
+type syncRequest struct {
+	Type   string `json:"type"`
+	Digest digest `json:"digest"`
+}
+
+// syncResponse lists the buckets which differ, and the responder's messages
+// in them.
+type syncResponse struct {
+	Buckets  []int  `json:"buckets"`
+	Messages packed `json:"runs"`
+}
+
+// antiEntropy runs a round of reconciliation every AntiEntropyInterval, with
+// each of the node's neighbours in turn.
+//
+// Batches only go to the node's neighbours at the time, so anything which
+// missed a neighbour while the overlay was being repaired, or was lost along
+// with a crashed node, is caught up here instead. A round costs one message
+// and its reply when the neighbours agree.
+func (s *Server) antiEntropy() {
+	for round := 0; ; round++ {
+		time.Sleep(s.cfg.AntiEntropyInterval)
+
+		neighbours := s.getNeighbours()
+		if len(neighbours) == 0 {
+			continue
+		}
+		s.reconcile(neighbours[round%len(neighbours)])
+	}
+}
+
+// reconcile exchanges digests with neighbour. The neighbour replies with its
+// messages in the buckets which differ, and is sent back this node's messages
+// in those buckets which it is missing.
+//
+// Batches are only sent when there are new messages, so a reply also counts
+// as a heartbeat, letting a suspected neighbour recover while the node is
+// idle.
+func (s *Server) reconcile(neighbour string) {
+	s.metrics.Counter("broadcast.anti_entropy_rounds").Inc()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.AntiEntropyInterval)
+	defer cancel()
+
+	msgs := s.allMessages()
+	reply, err := s.sender.SendSync(ctx, neighbour, syncRequest{Type: "sync", Digest: newDigest(msgs)})
+	if err != nil {
+		return
+	}
+	s.responded(neighbour)
+
+	var resp syncResponse
+	if err := json.Unmarshal(reply.Body, &resp); err != nil {
+		return
+	}
+	if len(resp.Buckets) == 0 {
+		return
+	}
+
+	// Messages pulled from the neighbour are new to the rest of our
+	// neighbours too, so they are passed on just as a batch would be.
+	if fresh := s.unseen(resp.Messages); len(fresh) > 0 {
+		s.relay(neighbour, fresh)
+	}
+	s.store(resp.Messages)
+
+	theirs := make(map[int]struct{}, len(resp.Messages))
+	for _, msg := range resp.Messages {
+		theirs[msg] = struct{}{}
+	}
+	missing := make([]int, 0)
+	for _, msg := range inBuckets(msgs, resp.Buckets) {
+		if _, ok := theirs[msg]; !ok {
+			missing = append(missing, msg)
+		}
+	}
+	s.metrics.Counter("broadcast.anti_entropy_received").Add(int64(len(resp.Messages)))
+	s.metrics.Counter("broadcast.anti_entropy_sent").Add(int64(len(missing)))
+
+	if len(missing) > 0 {
+		s.send(neighbour, missing)
+	}
+}
+
+func (s *Server) sync(ctx context.Context, req syncRequest) (syncResponse, error) {
+	msgs := s.allMessages()
+	buckets := newDigest(msgs).diff(req.Digest)
+
+	return syncResponse{Buckets: buckets, Messages: inBuckets(msgs, buckets)}, nil
+}
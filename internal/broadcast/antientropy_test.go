@@ -0,0 +1,141 @@
+package broadcast
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestDigestDiff(t *testing.T) {
+	msgs := make([]int, 0)
+	for i := 0; i < 1000; i++ {
+		msgs = append(msgs, i)
+	}
+
+	if buckets := newDigest(msgs).diff(newDigest(slices.Clone(msgs))); len(buckets) != 0 {
+		t.Fatalf("expected equal sets to agree, got %v", buckets)
+	}
+
+	// drop two messages, and add one the other side does not have
+	other := append(slices.Clone(msgs[2:]), 5000)
+	buckets := newDigest(msgs).diff(newDigest(other))
+
+	for _, msg := range []int{0, 1, 5000} {
+		if !slices.Contains(buckets, bucketOf(msg)) {
+			t.Errorf("expected the bucket of %d to differ, got %v", msg, buckets)
+		}
+	}
+	if len(buckets) > 3 {
+		t.Errorf("expected at most 3 buckets to differ, got %v", buckets)
+	}
+
+	// only the messages in those buckets need to be exchanged
+	if in := inBuckets(msgs, buckets); len(in) > len(msgs)/10 {
+		t.Errorf("expected a small transfer, got %d messages", len(in))
+	}
+}
+
+func TestAntiEntropyRepairsAfterPartition(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BatchInterval = 20 * time.Millisecond
+	cfg.SuspectTimeout = 0
+	// batches are given up on well before the partition heals, and an
+	// abandoned batch is only retried once it times out again, so
+	// anti-entropy catches up first
+	cfg.DeliveryTimeout = 300 * time.Millisecond
+	cfg.AntiEntropyInterval = 20 * time.Millisecond
+
+	net, servers, c, ctx := startCluster(t, cfg, 5)
+
+	// cut every node off from every other
+	nem := sim.NewNemesis(net, sim.NemesisConfig{})
+	groups := make([][]string, 0)
+	for _, id := range net.NodeIDs() {
+		groups = append(groups, []string{id})
+	}
+	nem.Apply(sim.Step{Fault: sim.PartitionIsolated, Groups: groups})
+
+	expected := make([]int, 0)
+	for i := 0; i < 20; i++ {
+		if _, err := c.RPC(ctx, net.NodeIDs()[i%5], broadcastRequest{Type: "broadcast", Message: i}); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, i)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	// the batches were given up on after the delivery timeout, and merged
+	// into the next batch rather than dropped
+	if abandoned := total(servers, "retry.abandoned"); abandoned == 0 {
+		t.Errorf("expected batches to be abandoned after the delivery timeout")
+	}
+	if failed := total(servers, "broadcast.batches_failed"); failed == 0 {
+		t.Errorf("expected batches to fail")
+	}
+	if coalesced := total(servers, "broadcast.coalesced_batches"); coalesced == 0 {
+		t.Errorf("expected failed batches to be merged into later ones")
+	}
+
+	nem.Apply(sim.Step{Fault: sim.Heal})
+	waitForAll(t, ctx, net, expected)
+
+	if repaired := total(servers, "broadcast.anti_entropy_received"); repaired == 0 {
+		t.Errorf("expected anti-entropy to have repaired messages")
+	}
+}
+
+func TestAntiEntropyRelaysPulledMessages(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Fanout = 1
+	cfg.BatchInterval = 20 * time.Millisecond
+	// rounds are run by hand instead
+	cfg.AntiEntropyInterval = time.Minute
+
+	// the tree is a line, n0 to n1 to n2
+	net, servers, _, ctx := startCluster(t, cfg, 3)
+
+	// only n0 has the messages, as if the batches carrying them were lost
+	expected := []int{1, 2, 3}
+	servers["n0"].store(expected)
+
+	// n1 pulls them from n0, and n2 only gets them if n1 passes them on
+	servers["n1"].reconcile("n0")
+	waitForAll(t, ctx, net, expected)
+}
+
+func TestAntiEntropyIdleWhenNeighboursAgree(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BatchInterval = 20 * time.Millisecond
+	cfg.AntiEntropyInterval = 20 * time.Millisecond
+
+	net, servers, c, ctx := startCluster(t, cfg, 5)
+
+	expected := make([]int, 0)
+	for i := 0; i < 50; i++ {
+		if _, err := c.RPC(ctx, net.NodeIDs()[i%5], broadcastRequest{Type: "broadcast", Message: i}); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, i)
+	}
+	waitForAll(t, ctx, net, expected)
+
+	// let any round which started before the nodes agreed finish
+	time.Sleep(100 * time.Millisecond)
+	rounds := total(servers, "broadcast.anti_entropy_rounds")
+	received := total(servers, "broadcast.anti_entropy_received")
+	sent := total(servers, "broadcast.anti_entropy_sent")
+
+	// the digests went over the wire, so they must survive being decoded
+	time.Sleep(200 * time.Millisecond)
+	if more := total(servers, "broadcast.anti_entropy_rounds"); more <= rounds {
+		t.Fatalf("expected more rounds, got %d then %d", rounds, more)
+	}
+	if more := total(servers, "broadcast.anti_entropy_received"); more != received {
+		t.Errorf("expected nothing to be received once the nodes agree, got %d more messages", more-received)
+	}
+	if more := total(servers, "broadcast.anti_entropy_sent"); more != sent {
+		t.Errorf("expected nothing to be sent once the nodes agree, got %d more messages", more-sent)
+	}
+}
@@ -0,0 +1,103 @@
+package broadcast
+
+import "time"
+
+// batcher decides when a batch of new messages is sent on to neighbours. A
+// batch is flushed as soon as any of these happens:
+//
+//   - it holds MaxBatchSize messages,
+//   - its oldest message has waited for the current delay,
+//   - no message has arrived for IdleTimeout.
+//
+// The delay adapts to how fast messages arrive, following a moving average of
+// the time between them. Under light load a batch would not reach
+// TargetBatchSize within BatchInterval, so waiting would only add latency,
+// and it is flushed straight away. Under heavy load the delay is however long
+// the rest of the batch is expected to take to arrive, so each batch carries
+// about TargetBatchSize operations for the same few messages per neighbour.
+//
+// It is not safe for concurrent use, and takes the time as an argument so
+// that it can be tested without waiting.
+type batcher struct {
+	cfg Config
+
+	pending []int
+	first   time.Time
+	last    time.Time
+
+	// gap is a moving average of the time between messages.
+	gap time.Duration
+}
+
+// gapWeight is how much each new gap counts towards the moving average.
+const gapWeight = 0.2
+
+func newBatcher(cfg Config) *batcher {
+	return &batcher{cfg: cfg, gap: cfg.BatchInterval}
+}
+
+// add queues msg, which arrived at now.
+func (b *batcher) add(msg int, now time.Time) {
+	if !b.last.IsZero() {
+		b.gap = time.Duration(gapWeight*float64(now.Sub(b.last)) + (1-gapWeight)*float64(b.gap))
+	}
+	b.last = now
+
+	if len(b.pending) == 0 {
+		b.first = now
+	}
+	b.pending = append(b.pending, msg)
+}
+
+// delay returns how long the oldest message in a batch may wait, given the
+// rate messages have been arriving at.
+func (b *batcher) delay() time.Duration {
+	if b.gap <= 0 || b.cfg.TargetBatchSize <= 1 {
+		return 0
+	}
+
+	// light load: the batch is not expected to fill within BatchInterval
+	fill := time.Duration(b.cfg.TargetBatchSize-1) * b.gap
+	if fill >= b.cfg.BatchInterval {
+		return 0
+	}
+	return fill
+}
+
+// deadline returns when the pending batch is due, or the zero time if there
+// is none.
+func (b *batcher) deadline() time.Time {
+	if len(b.pending) == 0 {
+		return time.Time{}
+	}
+
+	due := b.first.Add(b.delay())
+	if b.cfg.IdleTimeout > 0 {
+		due = minTime(due, b.last.Add(b.cfg.IdleTimeout))
+	}
+	return due
+}
+
+// flush returns the pending batch and starts a new one if the batch is due
+// at now, or nil if it is not.
+func (b *batcher) flush(now time.Time) []int {
+	if len(b.pending) == 0 {
+		return nil
+	}
+	if b.cfg.MaxBatchSize <= 0 || len(b.pending) < b.cfg.MaxBatchSize {
+		if now.Before(b.deadline()) {
+			return nil
+		}
+	}
+
+	msgs := b.pending
+	b.pending = nil
+	return msgs
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,114 @@
+package broadcast
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatcher(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BatchInterval = time.Second
+	cfg.MaxBatchSize = 10
+	cfg.TargetBatchSize = 4
+	cfg.IdleTimeout = 300 * time.Millisecond
+
+	start := time.Unix(0, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	t.Run("empty", func(t *testing.T) {
+		b := newBatcher(cfg)
+		if msgs := b.flush(at(5000)); msgs != nil {
+			t.Errorf("expected nothing to flush, got %v", msgs)
+		}
+		if due := b.deadline(); !due.IsZero() {
+			t.Errorf("expected no deadline, got %v", due)
+		}
+	})
+
+	t.Run("light load sends straight away", func(t *testing.T) {
+		b := newBatcher(cfg)
+		b.add(1, at(0))
+		if delay := b.delay(); delay != 0 {
+			t.Errorf("expected no delay, got %v", delay)
+		}
+		if msgs := b.flush(at(0)); len(msgs) != 1 {
+			t.Errorf("expected [1], got %v", msgs)
+		}
+
+		// at a message every 400ms, the batch would take 1.2s to fill
+		for i := 2; i <= 5; i++ {
+			b.add(i, at((i-1)*400))
+			if msgs := b.flush(at((i - 1) * 400)); len(msgs) != 1 {
+				t.Errorf("expected [%d], got %v", i, msgs)
+			}
+		}
+	})
+
+	t.Run("heavy load waits for the target size", func(t *testing.T) {
+		b := newBatcher(cfg)
+		for i := 0; i < 50; i++ {
+			b.add(i, at(i*50))
+		}
+		b.pending = nil
+
+		// a message every 50ms, so the other three take 150ms
+		b.add(50, at(2500))
+		if delay := b.delay(); delay < 100*time.Millisecond || delay > 200*time.Millisecond {
+			t.Errorf("expected a delay of about 150ms, got %v", delay)
+		}
+		if msgs := b.flush(at(2550)); msgs != nil {
+			t.Errorf("expected the batch to wait, got %v", msgs)
+		}
+		if msgs := b.flush(at(2750)); len(msgs) != 1 {
+			t.Errorf("expected [50] once the delay passed, got %v", msgs)
+		}
+	})
+
+	t.Run("max batch size", func(t *testing.T) {
+		b := newBatcher(cfg)
+		b.gap = 100 * time.Millisecond
+		for i := 0; i < 9; i++ {
+			b.add(i, at(i))
+		}
+		if msgs := b.flush(at(9)); msgs != nil {
+			t.Errorf("expected the batch to wait, got %v", msgs)
+		}
+		b.add(9, at(9))
+		if msgs := b.flush(at(9)); len(msgs) != 10 {
+			t.Errorf("expected 10 messages, got %v", msgs)
+		}
+	})
+
+	t.Run("idle timeout applies to any batch", func(t *testing.T) {
+		b := newBatcher(cfg)
+		b.gap = 250 * time.Millisecond
+
+		// the batch would wait 750ms to fill, but is cut short after 300ms
+		b.add(1, at(0))
+		if due := b.deadline(); !due.Equal(at(300)) {
+			t.Errorf("expected the batch to be due at the idle timeout, got %v", due.Sub(start))
+		}
+
+		// another message puts it off again
+		b.add(2, at(100))
+		if due := b.deadline(); !due.Equal(at(400)) {
+			t.Errorf("expected the batch to be due at the idle timeout, got %v", due.Sub(start))
+		}
+		if msgs := b.flush(at(399)); msgs != nil {
+			t.Errorf("expected the batch to wait, got %v", msgs)
+		}
+		if msgs := b.flush(at(400)); len(msgs) != 2 {
+			t.Errorf("expected [1 2], got %v", msgs)
+		}
+	})
+
+	t.Run("a target of one turns batching off", func(t *testing.T) {
+		cfg := cfg
+		cfg.TargetBatchSize = 1
+		b := newBatcher(cfg)
+		b.add(1, at(0))
+		if msgs := b.flush(at(0)); len(msgs) != 1 {
+			t.Errorf("expected [1], got %v", msgs)
+		}
+	})
+}
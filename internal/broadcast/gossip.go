@@ -0,0 +1,123 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// This file implements the gossip overlay, which replaces flooding over fixed
+// neighbours with push-pull gossip between random peers.
+//
+// Every GossipInterval a node picks GossipFanout peers at random from the
+// whole cluster. It pushes each of them the messages it has learned since the
+// last round, and pulls what it is missing by sending a digest of its
+// messages, which the peer answers as it would an anti-entropy "sync". Any
+// message a node learns, whether pushed or pulled, is pushed on in its next
+// round, so new messages spread like a rumour while the pulls make sure
+// nothing is missed.
+
+type gossipRequest struct {
+	Type     string `json:"type"`
+	Messages packed `json:"runs"`
+	Digest   digest `json:"digest"`
+}
+
+// pushPull runs a round of push-pull gossip every GossipInterval.
+func (s *Server) pushPull() {
+	for {
+		time.Sleep(s.cfg.GossipInterval)
+
+	L:
+		for {
+			select {
+			case msg := <-s.messagesChan:
+				s.spread([]int{msg})
+			default:
+				break L
+			}
+		}
+
+		peers := s.gossipPeers()
+		if len(peers) == 0 {
+			continue
+		}
+
+		s.gossipLock.Lock()
+		rumours := s.rumours
+		s.rumours = nil
+		s.gossipLock.Unlock()
+
+		s.metrics.Counter("broadcast.gossip_rounds").Inc()
+		s.metrics.Counter("broadcast.gossip_pushed").Add(int64(len(rumours) * len(peers)))
+
+		d := newDigest(s.allMessages())
+		for _, peer := range peers {
+			s.exchange(peer, gossipRequest{Type: "gossip", Messages: rumours, Digest: d})
+		}
+	}
+}
+
+// gossipPeers picks up to GossipFanout other nodes at random.
+func (s *Server) gossipPeers() []string {
+	s.neighboursLock.RLock()
+	peers := s.peers
+	s.neighboursLock.RUnlock()
+
+	k := min(max(s.cfg.GossipFanout, 1), len(peers))
+	picked := make([]string, 0, k)
+	for _, i := range rand.Perm(len(peers))[:k] {
+		picked = append(picked, peers[i])
+	}
+	return picked
+}
+
+// exchange sends req to peer in the background, and stores and spreads the
+// messages it sends back.
+func (s *Server) exchange(peer string, req gossipRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.GossipInterval)
+	s.sender.Send(ctx, peer, req, func(reply maelstrom.Message, err error) {
+		cancel()
+		if err != nil {
+			return
+		}
+
+		var resp syncResponse
+		if err := json.Unmarshal(reply.Body, &resp); err != nil {
+			return
+		}
+
+		// peers which agree send nothing back, so any messages at all in
+		// the reply are what the pull costs
+		fresh := s.unseen(resp.Messages)
+		s.store(fresh)
+		s.spread(fresh)
+		s.metrics.Counter("broadcast.gossip_pull_replies").Add(int64(len(resp.Messages)))
+		s.metrics.Counter("broadcast.gossip_pulled").Add(int64(len(fresh)))
+	})
+}
+
+// spread queues msgs to be pushed in the next round.
+func (s *Server) spread(msgs []int) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	s.gossipLock.Lock()
+	defer s.gossipLock.Unlock()
+
+	s.rumours = append(s.rumours, msgs...)
+}
+
+func (s *Server) gossip(ctx context.Context, req gossipRequest) (syncResponse, error) {
+	s.buildTree()
+
+	fresh := s.unseen(req.Messages)
+	s.store(fresh)
+	s.spread(fresh)
+
+	return s.sync(ctx, syncRequest{Type: "sync", Digest: req.Digest})
+}
@@ -0,0 +1,54 @@
+package broadcast
+
+import (
+	"testing"
+	"time"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestGossipConvergesAfterPartition(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Overlay = OverlayGossip
+	cfg.GossipFanout = 2
+	cfg.GossipInterval = 100 * time.Millisecond
+	cfg.AntiEntropyInterval = 0
+
+	net, servers, c, ctx := startCluster(t, cfg, 5)
+
+	// n4 misses every push while it is cut off, so only pulls can catch it up
+	nem := sim.NewNemesis(net, sim.NemesisConfig{})
+	nem.Apply(sim.Step{Fault: sim.PartitionIsolated, Groups: [][]string{{"n4"}, nodeIDs(4)}})
+
+	expected := make([]int, 0)
+	for i := 0; i < 20; i++ {
+		if _, err := c.RPC(ctx, net.NodeIDs()[i%4], broadcastRequest{Type: "broadcast", Message: i}); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, i)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	nem.Apply(sim.Step{Fault: sim.Heal})
+	waitForAll(t, ctx, net, expected)
+
+	if pulled := total(servers, "broadcast.gossip_pulled"); pulled == 0 {
+		t.Errorf("expected messages to be pulled")
+	}
+	// nothing is flooded over neighbours
+	if batches := total(servers, "broadcast.batches"); batches != 0 {
+		t.Errorf("expected no batches, got %d", batches)
+	}
+
+	// once every node agrees, pulls come back empty
+	time.Sleep(3 * cfg.GossipInterval)
+	rounds := total(servers, "broadcast.gossip_rounds")
+	replies := total(servers, "broadcast.gossip_pull_replies")
+	time.Sleep(3 * cfg.GossipInterval)
+	if more := total(servers, "broadcast.gossip_rounds"); more <= rounds {
+		t.Fatalf("expected more rounds, got %d then %d", rounds, more)
+	}
+	if more := total(servers, "broadcast.gossip_pull_replies"); more != replies {
+		t.Errorf("expected empty pulls once the nodes agree, got %d more messages", more-replies)
+	}
+}
@@ -0,0 +1,136 @@
+package broadcast
+
+import (
+	"context"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// outbox holds the messages waiting to be sent to one neighbour.
+//
+// At most one batch is in flight to a neighbour at a time. Messages queued
+// while it is in flight, and the batch itself if it is not acknowledged within
+// DeliveryTimeout, are merged into the next one. So a neighbour which cannot
+// be reached costs one retrying RPC rather than one per batch, and once it can
+// be reached again, a single batch carries everything it missed.
+type outbox struct {
+	pending  map[int]struct{}
+	inFlight bool
+}
+
+// take returns the pending messages and marks them as in flight.
+func (o *outbox) take() []int {
+	msgs := make([]int, 0, len(o.pending))
+	for msg := range o.pending {
+		msgs = append(msgs, msg)
+	}
+	clear(o.pending)
+	o.inFlight = true
+	return msgs
+}
+
+// send queues msgs for neighbour, and sends them straight away unless a batch
+// is already in flight to it.
+func (s *Server) send(neighbour string, msgs []int) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	s.outboxLock.Lock()
+	o, ok := s.outboxes[neighbour]
+	if !ok {
+		o = &outbox{pending: make(map[int]struct{})}
+		s.outboxes[neighbour] = o
+	}
+	for _, msg := range msgs {
+		o.pending[msg] = struct{}{}
+	}
+
+	var batch []int
+	if !o.inFlight {
+		batch = o.take()
+	}
+	s.updateOutboxGauge()
+	s.outboxLock.Unlock()
+
+	if batch != nil {
+		s.deliver(neighbour, batch)
+	}
+}
+
+// deliver sends a batch to neighbour in the background, recording how long it
+// takes to be acknowledged and whether the neighbour is responsive. When it is
+// done, the next batch from the outbox goes out.
+//
+// Every SuspectTimeout the batch goes unacknowledged counts as a timeout.
+func (s *Server) deliver(neighbour string, msgs []int) {
+	start := time.Now()
+
+	acked := make(chan struct{})
+	if s.cfg.SuspectTimeout > 0 {
+		go func() {
+			ticker := time.NewTicker(s.cfg.SuspectTimeout)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-acked:
+					return
+				case <-ticker.C:
+					s.timedOut(neighbour)
+				}
+			}
+		}()
+	}
+
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if s.cfg.DeliveryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.DeliveryTimeout)
+	}
+
+	req := broadcastBatchRequest{Type: "broadcast_batch", Message: msgs}
+	s.sender.Send(ctx, neighbour, req, func(reply maelstrom.Message, err error) {
+		cancel()
+		close(acked)
+
+		if err == nil {
+			s.responded(neighbour)
+			s.metrics.Histogram("broadcast.batch_latency").Since(start)
+		} else {
+			s.metrics.Counter("broadcast.batches_failed").Inc()
+		}
+
+		s.outboxLock.Lock()
+		o := s.outboxes[neighbour]
+		if err != nil {
+			// merged into the next batch rather than dropped
+			for _, msg := range msgs {
+				o.pending[msg] = struct{}{}
+			}
+		}
+
+		var next []int
+		o.inFlight = false
+		if len(o.pending) > 0 {
+			next = o.take()
+		}
+		s.updateOutboxGauge()
+		s.outboxLock.Unlock()
+
+		if next != nil {
+			s.metrics.Counter("broadcast.coalesced_batches").Inc()
+			s.deliver(neighbour, next)
+		}
+	})
+}
+
+// updateOutboxGauge records how many messages are waiting across all the
+// outboxes. It must be called with outboxLock held.
+func (s *Server) updateOutboxGauge() {
+	pending := 0
+	for _, o := range s.outboxes {
+		pending += len(o.pending)
+	}
+	s.metrics.Gauge("broadcast.outbox_pending").Set(int64(pending))
+}
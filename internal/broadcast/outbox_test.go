@@ -0,0 +1,47 @@
+package broadcast
+
+import (
+	"testing"
+	"time"
+
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestOutboxCoalescesWhilePartitioned(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BatchInterval = 20 * time.Millisecond
+	cfg.DeliveryTimeout = 100 * time.Millisecond
+	cfg.SuspectTimeout = 0
+	cfg.AntiEntropyInterval = 0
+
+	net, servers, c, ctx := startCluster(t, cfg, 2)
+
+	nem := sim.NewNemesis(net, sim.NemesisConfig{})
+	nem.Apply(sim.Step{Fault: sim.PartitionIsolated, Groups: [][]string{{"n0"}, {"n1"}}})
+
+	// spaced out, so that each is its own batch
+	expected := make([]int, 0)
+	for i := 0; i < 10; i++ {
+		if _, err := c.RPC(ctx, "n0", broadcastRequest{Type: "broadcast", Message: i}); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, i)
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	n0 := metrics.For(servers["n0"].n)
+	if batches := n0.Counter("broadcast.batches").Value(); batches < 2 {
+		t.Fatalf("expected several batches, got %d", batches)
+	}
+	if inFlight := n0.Gauge("retry.in_flight").Value(); inFlight > 1 {
+		t.Errorf("expected one batch in flight to n1, got %d", inFlight)
+	}
+
+	nem.Apply(sim.Step{Fault: sim.Heal})
+	waitForAll(t, ctx, net, expected)
+
+	if coalesced := n0.Counter("broadcast.coalesced_batches").Value(); coalesced == 0 {
+		t.Errorf("expected pending messages to be merged into later batches")
+	}
+}
@@ -0,0 +1,186 @@
+package broadcast
+
+import (
+	"context"
+	"slices"
+)
+
+// Overlay selects the graph of nodes messages are broadcast over.
+type Overlay string
+
+const (
+	// OverlayTree ignores the topology maelstrom supplies and broadcasts over
+	// a spanning tree built from the node IDs, see Tree.
+	OverlayTree Overlay = "tree"
+
+	// OverlayTopology broadcasts over the topology maelstrom supplies, so
+	// performance depends on its --topology flag.
+	OverlayTopology Overlay = "topology"
+
+	// OverlayPlumtree pushes messages eagerly along the same tree as
+	// OverlayTree, and announces them lazily to a few other peers so that the
+	// tree can be repaired, see plumtree.go.
+	OverlayPlumtree Overlay = "plumtree"
+
+	// OverlayGossip has no fixed graph: messages are exchanged with random
+	// peers by push-pull gossip, see gossip.go.
+	OverlayGossip Overlay = "gossip"
+)
+
+// Tree returns the neighbours of every node in a spanning tree of ids, in
+// which each node has at most fanout children.
+//
+// Nodes are laid out in the order given, as in a binary heap: the first node
+// is the root, the next fanout nodes are its children, and so on. Every level
+// is full before the next one starts, so the tree is as shallow as it can be
+// and the longest path between two nodes is at most twice its depth. Every
+// node computes the same tree as long as it is given the same ids, which
+// maelstrom guarantees by sending every node the same node_ids on init.
+func Tree(ids []string, fanout int) map[string][]string {
+	fanout = max(fanout, 1)
+
+	tree := make(map[string][]string, len(ids))
+	for i, id := range ids {
+		neighbours := make([]string, 0, fanout+1)
+		if i > 0 {
+			neighbours = append(neighbours, ids[(i-1)/fanout])
+		}
+		for c := fanout*i + 1; c <= fanout*i+fanout && c < len(ids); c++ {
+			neighbours = append(neighbours, ids[c])
+		}
+		tree[id] = neighbours
+	}
+	return tree
+}
+
+func (s *Server) topology(ctx context.Context, req topologyRequest) (struct{}, error) {
+	if s.cfg.Overlay != OverlayTopology {
+		// the tree is built from the node IDs instead
+		s.buildTree()
+		return struct{}{}, nil
+	}
+
+	s.neighboursLock.Lock()
+	s.id = s.n.ID()
+	s.graph = req.Topology
+	s.neighbours = req.Topology[s.id]
+	s.neighboursLock.Unlock()
+
+	return struct{}{}, nil
+}
+
+// buildTree sets the neighbours from a spanning tree of the cluster, if the
+// overlay is built from the node IDs and it has not been built yet. In gossip
+// mode there are no neighbours, only the peers to pick from.
+//
+// It must only be called from a handler: the node's IDs are not safe to read
+// from other goroutines, since they are written when the node is initialised.
+func (s *Server) buildTree() {
+	if s.cfg.Overlay == OverlayTopology {
+		return
+	}
+
+	s.neighboursLock.Lock()
+	defer s.neighboursLock.Unlock()
+
+	if s.graph != nil {
+		return
+	}
+
+	s.id = s.n.ID()
+	if s.cfg.Overlay == OverlayGossip {
+		s.graph = make(map[string][]string)
+		s.peers = slices.DeleteFunc(slices.Clone(s.n.NodeIDs()), func(id string) bool { return id == s.id })
+		return
+	}
+
+	s.graph = Tree(s.n.NodeIDs(), s.cfg.Fanout)
+	s.neighbours = s.graph[s.id]
+	if s.cfg.Overlay == OverlayPlumtree {
+		s.lazy = LazyPeers(s.n.NodeIDs(), s.id, s.neighbours, s.cfg.LazyFanout)
+	}
+}
+
+// getNeighbours returns the nodes to send batches to: the node's neighbours in
+// the overlay and, for every suspected neighbour, that neighbour's own
+// neighbours as backup edges.
+//
+// Suspected neighbours are still sent to, both so that they catch up and so
+// that their recovery is noticed.
+func (s *Server) getNeighbours() []string {
+	s.neighboursLock.RLock()
+	defer s.neighboursLock.RUnlock()
+
+	if len(s.suspected) == 0 {
+		return s.neighbours
+	}
+	return append(slices.Clone(s.neighbours), s.backups()...)
+}
+
+// backups returns the backup edges for the suspected neighbours. It must be
+// called with neighboursLock held.
+func (s *Server) backups() []string {
+	backups := make([]string, 0)
+	for _, suspect := range s.neighbours {
+		if !s.suspected[suspect] {
+			continue
+		}
+		for _, backup := range s.graph[suspect] {
+			if backup != s.id && !s.suspected[backup] && !slices.Contains(s.neighbours, backup) && !slices.Contains(backups, backup) {
+				backups = append(backups, backup)
+			}
+		}
+	}
+	return backups
+}
+
+// timedOut records that neighbour has not acknowledged a batch in time, and
+// suspects it once that has happened SuspectAfter times in a row.
+//
+// Batches already on their way to the suspect never reached the rest of the
+// overlay through it, so the new backup edges are sent every message this
+// node has to catch them up.
+func (s *Server) timedOut(neighbour string) {
+	s.neighboursLock.Lock()
+	s.timeouts[neighbour]++
+	if s.timeouts[neighbour] < max(s.cfg.SuspectAfter, 1) || s.suspected[neighbour] {
+		s.neighboursLock.Unlock()
+		return
+	}
+
+	before := s.backups()
+	s.suspected[neighbour] = true
+	s.metrics.Counter("broadcast.suspected").Inc()
+	s.metrics.Gauge("broadcast.suspected_neighbours").Set(int64(len(s.suspected)))
+
+	added := make([]string, 0)
+	for _, backup := range s.backups() {
+		if !slices.Contains(before, backup) {
+			added = append(added, backup)
+		}
+	}
+	s.neighboursLock.Unlock()
+
+	if len(added) == 0 {
+		return
+	}
+
+	msgs := s.allMessages()
+	for _, backup := range added {
+		s.send(backup, msgs)
+	}
+}
+
+// responded records that neighbour has acknowledged a batch, which clears any
+// suspicion and so removes its backup edges.
+func (s *Server) responded(neighbour string) {
+	s.neighboursLock.Lock()
+	defer s.neighboursLock.Unlock()
+
+	delete(s.timeouts, neighbour)
+	if s.suspected[neighbour] {
+		delete(s.suspected, neighbour)
+		s.metrics.Counter("broadcast.recovered").Inc()
+		s.metrics.Gauge("broadcast.suspected_neighbours").Set(int64(len(s.suspected)))
+	}
+}
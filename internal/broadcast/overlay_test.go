@@ -0,0 +1,119 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func nodeIDs(count int) []string {
+	ids := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		ids = append(ids, fmt.Sprintf("n%d", i))
+	}
+	return ids
+}
+
+// startCluster starts count nodes running cfg, and sends each an empty
+// topology, so that they build their overlay. The network is closed and the
+// context cancelled when the test finishes.
+func startCluster(t *testing.T, cfg Config, count int) (*sim.Network, map[string]*Server, *sim.Client, context.Context) {
+	t.Helper()
+
+	servers := make(map[string]*Server)
+	net := sim.NewNetwork(sim.Config{Latency: 2 * time.Millisecond})
+	for _, id := range nodeIDs(count) {
+		s := NewServer(maelstrom.NewNode(), cfg)
+		net.AddNode(id, s.n)
+		servers[id] = s
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(net.Close)
+
+	c := net.Client("c1")
+	for _, id := range net.NodeIDs() {
+		if _, err := c.RPC(ctx, id, map[string]any{"type": "topology", "topology": map[string][]string{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return net, servers, c, ctx
+}
+
+// distances returns how many hops each node is from start.
+func distances(graph map[string][]string, start string) map[string]int {
+	dist := map[string]int{start: 0}
+	queue := []string{start}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range graph[id] {
+			if _, ok := dist[next]; !ok {
+				dist[next] = dist[id] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+	return dist
+}
+
+func TestTree(t *testing.T) {
+	for _, tc := range []struct {
+		nodes, fanout, diameter int
+	}{
+		{1, 4, 0},
+		{5, 4, 2},
+		{25, 4, 5},
+		{25, 2, 8},
+		{25, 1, 24},
+	} {
+		t.Run(fmt.Sprintf("%d nodes, fanout %d", tc.nodes, tc.fanout), func(t *testing.T) {
+			ids := nodeIDs(tc.nodes)
+			tree := Tree(ids, tc.fanout)
+
+			edges := 0
+			for id, neighbours := range tree {
+				if len(neighbours) > tc.fanout+1 {
+					t.Errorf("%s: expected at most %d neighbours, got %v", id, tc.fanout+1, neighbours)
+				}
+				for _, other := range neighbours {
+					if !slices.Contains(tree[other], id) {
+						t.Errorf("expected the edge %s-%s both ways", id, other)
+					}
+				}
+				edges += len(neighbours)
+			}
+
+			// a spanning tree has one edge fewer than it has nodes
+			if edges/2 != tc.nodes-1 {
+				t.Errorf("expected %d edges, got %d", tc.nodes-1, edges/2)
+			}
+
+			diameter := 0
+			for _, id := range ids {
+				dist := distances(tree, id)
+				if len(dist) != tc.nodes {
+					t.Fatalf("%s: expected to reach %d nodes, reached %d", id, tc.nodes, len(dist))
+				}
+				for _, d := range dist {
+					diameter = max(diameter, d)
+				}
+			}
+			if diameter != tc.diameter {
+				t.Errorf("expected diameter %d, got %d", tc.diameter, diameter)
+			}
+		})
+	}
+}
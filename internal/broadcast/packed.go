@@ -0,0 +1,80 @@
+package broadcast
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// packed is a set of messages as sent between nodes. Instead of an array of
+// every value, it is encoded in JSON as its sorted values split into runs of
+// consecutive integers, each given as a pair: the gap from the end of the
+// previous run (or from zero, for the first) and the length of the run.
+//
+// For example, 3, 4, 5, 9, 10 and 20 are encoded as [3,3, 3,2, 9,1].
+// Maelstrom broadcasts consecutive integers, so the full sets sent to catch
+// up backup edges pack into a few runs. Sparse sets, such as the messages in
+// the hash buckets anti-entropy exchanges, cost two numbers per value, but the
+// gaps are shorter to write out than the values themselves.
+type packed []int
+
+// maxPacked is the most values a packed set may expand to. The lengths come
+// from other nodes, so a corrupt run must not be able to exhaust memory.
+const maxPacked = 1 << 20
+
+func (p packed) MarshalJSON() ([]byte, error) {
+	msgs := slices.Clone(p)
+	slices.Sort(msgs)
+	msgs = slices.Compact(msgs)
+
+	runs := make([]int, 0)
+	end := 0
+	for i := 0; i < len(msgs); {
+		j := i + 1
+		for j < len(msgs) && msgs[j] == msgs[j-1]+1 {
+			j++
+		}
+		runs = append(runs, msgs[i]-end, j-i)
+		end = msgs[j-1] + 1
+		i = j
+	}
+	return json.Marshal(runs)
+}
+
+func (p *packed) UnmarshalJSON(data []byte) error {
+	var runs []int
+	if err := json.Unmarshal(data, &runs); err != nil {
+		return err
+	}
+	if len(runs)%2 != 0 {
+		return fmt.Errorf("runs must come in pairs of gap and length, got %d numbers", len(runs))
+	}
+
+	msgs := make([]int, 0, len(runs)/2)
+	end := 0
+	for i := 0; i < len(runs); i += 2 {
+		gap, length := runs[i], runs[i+1]
+		if i > 0 && gap < 0 {
+			return fmt.Errorf("run %d overlaps the one before it", i/2)
+		}
+		if length < 1 {
+			return fmt.Errorf("run %d has length %d", i/2, length)
+		}
+		if length > maxPacked-len(msgs) {
+			return fmt.Errorf("run %d has length %d, more than the %d values a set may hold", i/2, length, maxPacked)
+		}
+
+		start := end + gap
+		if (gap > 0 && start < end) || start > math.MaxInt-length {
+			return fmt.Errorf("run %d ends past the largest integer", i/2)
+		}
+		for msg := start; msg < start+length; msg++ {
+			msgs = append(msgs, msg)
+		}
+		end = start + length
+	}
+
+	*p = msgs
+	return nil
+}
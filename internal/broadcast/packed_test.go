@@ -0,0 +1,55 @@
+package broadcast
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestPacked(t *testing.T) {
+	for _, tc := range []struct {
+		msgs    []int
+		encoded string
+		decoded []int
+	}{
+		{nil, `[]`, []int{}},
+		{[]int{20, 4, 3, 9, 5, 10, 4}, `[3,3,3,2,9,1]`, []int{3, 4, 5, 9, 10, 20}},
+		{[]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, `[0,10]`, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{[]int{-2, -1, 1}, `[-2,2,1,1]`, []int{-2, -1, 1}},
+		{[]int{-10, -9, -5}, `[-10,2,3,1]`, []int{-10, -9, -5}},
+	} {
+		buf, err := json.Marshal(packed(tc.msgs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != tc.encoded {
+			t.Errorf("%v: expected %s, got %s", tc.msgs, tc.encoded, buf)
+		}
+
+		var p packed
+		if err := json.Unmarshal(buf, &p); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(p, tc.decoded) {
+			t.Errorf("%s: expected %v, got %v", buf, tc.decoded, p)
+		}
+	}
+}
+
+func TestPackedRejectsMalformedRuns(t *testing.T) {
+	for _, encoded := range []string{
+		`[1]`,
+		`[1,0]`,
+		`[1,2,-1,1]`,
+		`[0,-5]`,
+		`[0,1000000000000]`,
+		`[0,1048576,0,1]`,
+		`[1,1,9223372036854775806,1]`,
+		`{"runs":[]}`,
+	} {
+		var p packed
+		if err := json.Unmarshal([]byte(encoded), &p); err == nil {
+			t.Errorf("%s: expected an error, got %v", encoded, p)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package broadcast
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/rpc"
+)
+
+// This file implements the Plumtree overlay, after "Epidemic Broadcast Trees"
+// by Leitão, Pereira and Rodrigues.
+//
+// Every node has eager peers, which it pushes messages to, and lazy peers,
+// which it only announces them to with an "ihave". The eager peers start out
+// as the node's neighbours in Tree, and the lazy peers as a few other nodes,
+// see LazyPeers. The tree then repairs itself:
+//
+//   - A node which is announced a message it does not have, and which does
+//     not arrive through the tree within GraftTimeout, sends a "graft" to the
+//     announcer. Both make each other eager peers, and the announcer sends the
+//     missing messages.
+//   - A node which is pushed a batch it has already seen by an eager peer
+//     sends it a "prune". Both make each other lazy peers, which removes the
+//     cycle the grafts created once the tree is whole again.
+//
+// Messages are their own IDs here, so an announcement could deliver them
+// outright. It is only treated as a hint, so that messages keep flowing
+// through the tree and the tree is what gets repaired.
+
+type ihaveRequest struct {
+	Type     string `json:"type"`
+	Messages packed `json:"runs"`
+}
+
+type graftRequest struct {
+	Type     string `json:"type"`
+	Messages packed `json:"runs"`
+}
+
+type pruneRequest struct {
+	Type string `json:"type"`
+}
+
+// LazyPeers returns the lazy peers of id: the next lazyFanout nodes after it
+// in ids, wrapping around and skipping its eager peers.
+func LazyPeers(ids []string, id string, eager []string, lazyFanout int) []string {
+	i := slices.Index(ids, id)
+	lazy := make([]string, 0, lazyFanout)
+	for j := 1; j < len(ids) && len(lazy) < lazyFanout; j++ {
+		peer := ids[(i+j)%len(ids)]
+		if !slices.Contains(eager, peer) {
+			lazy = append(lazy, peer)
+		}
+	}
+	return lazy
+}
+
+func (s *Server) lazyPeers() []string {
+	s.neighboursLock.RLock()
+	defer s.neighboursLock.RUnlock()
+
+	return s.lazy
+}
+
+// promote makes peer an eager peer.
+func (s *Server) promote(peer string) {
+	s.neighboursLock.Lock()
+	defer s.neighboursLock.Unlock()
+
+	// the slices are replaced rather than modified, since getNeighbours hands
+	// them out
+	if !slices.Contains(s.neighbours, peer) {
+		s.neighbours = append(slices.Clone(s.neighbours), peer)
+	}
+	s.lazy = slices.DeleteFunc(slices.Clone(s.lazy), func(p string) bool { return p == peer })
+}
+
+// demote makes peer a lazy peer, returning false if it was not an eager one.
+func (s *Server) demote(peer string) bool {
+	s.neighboursLock.Lock()
+	defer s.neighboursLock.Unlock()
+
+	if !slices.Contains(s.neighbours, peer) {
+		return false
+	}
+	s.neighbours = slices.DeleteFunc(slices.Clone(s.neighbours), func(p string) bool { return p == peer })
+	if !slices.Contains(s.lazy, peer) {
+		s.lazy = append(slices.Clone(s.lazy), peer)
+	}
+	return true
+}
+
+// eagerReceived handles a batch of msgs pushed by src, of which fresh had not
+// been seen. Fresh messages are announced to the lazy peers at the next
+// batch, and a batch with nothing fresh prunes src from the tree.
+func (s *Server) eagerReceived(src string, msgs []int, fresh []int) {
+	if len(fresh) > 0 {
+		s.plumtreeLock.Lock()
+		s.announce = append(s.announce, fresh...)
+		s.plumtreeLock.Unlock()
+		return
+	}
+
+	// an empty batch is not a duplicate
+	if len(msgs) == 0 || !s.demote(src) {
+		return
+	}
+	s.metrics.Counter("broadcast.prunes_sent").Inc()
+	s.notify(src, pruneRequest{Type: "prune"})
+}
+
+// lazyPush announces msgs, along with the messages received since the last
+// batch, to the lazy peers.
+func (s *Server) lazyPush(msgs []int) {
+	if s.cfg.Overlay != OverlayPlumtree {
+		return
+	}
+
+	s.plumtreeLock.Lock()
+	ids := append(s.announce, msgs...)
+	s.announce = nil
+	s.plumtreeLock.Unlock()
+
+	if len(ids) == 0 {
+		return
+	}
+	for _, peer := range s.lazyPeers() {
+		s.metrics.Counter("broadcast.ihaves_sent").Inc()
+		s.notify(peer, ihaveRequest{Type: "ihave", Messages: ids})
+	}
+}
+
+// notify sends a Plumtree control message to dst in the background, retrying
+// it for DeliveryTimeout.
+func (s *Server) notify(dst string, body any) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if s.cfg.DeliveryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.DeliveryTimeout)
+	}
+	s.sender.Send(ctx, dst, body, func(reply maelstrom.Message, err error) { cancel() })
+}
+
+func (s *Server) ihave(ctx context.Context, req ihaveRequest) (struct{}, error) {
+	src := rpc.Message(ctx).Src
+	s.buildTree()
+
+	s.plumtreeLock.Lock()
+	wanted := make([]int, 0)
+	for _, msg := range s.unseen(req.Messages) {
+		if !s.missing[msg] {
+			s.missing[msg] = true
+			wanted = append(wanted, msg)
+		}
+	}
+	s.plumtreeLock.Unlock()
+
+	if len(wanted) > 0 {
+		time.AfterFunc(s.cfg.GraftTimeout, func() { s.requestGraft(src, wanted) })
+	}
+	return struct{}{}, nil
+}
+
+// requestGraft grafts peer onto the tree if any of msgs, which it announced,
+// have still not arrived.
+func (s *Server) requestGraft(peer string, msgs []int) {
+	s.plumtreeLock.Lock()
+	for _, msg := range msgs {
+		delete(s.missing, msg)
+	}
+	s.plumtreeLock.Unlock()
+
+	msgs = s.unseen(msgs)
+	if len(msgs) == 0 {
+		return
+	}
+
+	s.promote(peer)
+	s.metrics.Counter("broadcast.grafts_sent").Inc()
+	s.notify(peer, graftRequest{Type: "graft", Messages: msgs})
+}
+
+func (s *Server) graft(ctx context.Context, req graftRequest) (struct{}, error) {
+	src := rpc.Message(ctx).Src
+	s.buildTree()
+
+	s.promote(src)
+	s.metrics.Counter("broadcast.grafts_received").Inc()
+
+	have := make([]int, 0)
+	unseen := s.unseen(req.Messages)
+	for _, msg := range req.Messages {
+		if !slices.Contains(unseen, msg) {
+			have = append(have, msg)
+		}
+	}
+	if len(have) > 0 {
+		s.send(src, have)
+	}
+	return struct{}{}, nil
+}
+
+func (s *Server) prune(ctx context.Context, req pruneRequest) (struct{}, error) {
+	s.buildTree()
+
+	if s.demote(rpc.Message(ctx).Src) {
+		s.metrics.Counter("broadcast.prunes_received").Inc()
+	}
+	return struct{}{}, nil
+}
@@ -0,0 +1,88 @@
+package broadcast
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestLazyPeers(t *testing.T) {
+	ids := nodeIDs(7)
+	tree := Tree(ids, 2)
+
+	// n5 is a leaf below n2, and wraps around past n6 to n0
+	if lazy := LazyPeers(ids, "n5", tree["n5"], 3); !slices.Equal(lazy, []string{"n6", "n0", "n1"}) {
+		t.Errorf("expected [n6 n0 n1], got %v", lazy)
+	}
+	// n1's children n3 and n4 are eager, so are skipped
+	if lazy := LazyPeers(ids, "n1", tree["n1"], 2); !slices.Equal(lazy, []string{"n2", "n5"}) {
+		t.Errorf("expected [n2 n5], got %v", lazy)
+	}
+	if lazy := LazyPeers(ids[:1], "n0", nil, 2); len(lazy) != 0 {
+		t.Errorf("expected no lazy peers for a single node, got %v", lazy)
+	}
+}
+
+// total sums a counter across servers.
+func total(servers map[string]*Server, name string) int64 {
+	sum := int64(0)
+	for _, s := range servers {
+		sum += metrics.For(s.n).Snapshot().Counters[name]
+	}
+	return sum
+}
+
+func TestPlumtreeGraftsAroundPartition(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Overlay = OverlayPlumtree
+	cfg.Fanout = 2
+	cfg.BatchInterval = 20 * time.Millisecond
+	cfg.GraftTimeout = 200 * time.Millisecond
+	cfg.DeliveryTimeout = 500 * time.Millisecond
+	// only Plumtree repairs the tree
+	cfg.SuspectTimeout = 0
+	cfg.AntiEntropyInterval = 0
+
+	net, servers, c, ctx := startCluster(t, cfg, 7)
+
+	expected := make([]int, 0)
+	broadcast := func(ids ...string) {
+		t.Helper()
+		for _, id := range ids {
+			msg := len(expected)
+			if _, err := c.RPC(ctx, id, broadcastRequest{Type: "broadcast", Message: msg}); err != nil {
+				t.Fatal(err)
+			}
+			expected = append(expected, msg)
+		}
+		waitForAll(t, ctx, net, expected)
+	}
+
+	// the tree is whole, so the announcements are never needed
+	broadcast("n3")
+	if grafts := total(servers, "broadcast.grafts_sent"); grafts != 0 {
+		t.Errorf("expected no grafts while the tree is whole, got %d", grafts)
+	}
+	if ihaves := total(servers, "broadcast.ihaves_sent"); ihaves == 0 {
+		t.Errorf("expected messages to be announced to lazy peers")
+	}
+
+	// cutting the n0-n1 edge splits the tree, so the halves graft onto each
+	// other's lazy peers
+	nem := sim.NewNemesis(net, sim.NemesisConfig{})
+	nem.Apply(sim.Step{Fault: sim.PartitionIsolated, Groups: [][]string{{"n0"}, {"n1"}}})
+	broadcast("n3", "n6", "n0")
+	if grafts := total(servers, "broadcast.grafts_sent"); grafts == 0 {
+		t.Errorf("expected grafts to repair the tree")
+	}
+
+	// once the edge is back, the grafts form cycles which are pruned
+	nem.Apply(sim.Step{Fault: sim.Heal})
+	broadcast("n3", "n4", "n5", "n6", "n0", "n1", "n2")
+	if prunes := total(servers, "broadcast.prunes_sent"); prunes == 0 {
+		t.Errorf("expected duplicates to prune the tree")
+	}
+}
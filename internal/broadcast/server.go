@@ -0,0 +1,384 @@
+// Package broadcast implements the broadcast challenge, batching messages
+// between nodes.
+package broadcast
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/health"
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/retry"
+	"fly-io-dist-sys/internal/rpc"
+)
+
+type readRequest struct {
+	Type string `json:"type"`
+
+	// Since, if set, asks for only the messages the node has seen after the
+	// cursor returned by an earlier read. Zero returns every message.
+	Since *int `json:"since,omitempty"`
+}
+
+// readResponse is the standard read_ok, with a cursor for the next read added
+// only when the request gave one, so plain reads are unchanged.
+type readResponse struct {
+	Messages []int `json:"messages"`
+	Cursor   *int  `json:"cursor,omitempty"`
+}
+
+type topologyRequest struct {
+	Topology map[string][]string
+}
+
+type broadcastRequest struct {
+	Type    string `json:"type"`
+	Message int    `json:"message"`
+}
+
+type broadcastBatchRequest struct {
+	Type    string `json:"type"`
+	Message packed `json:"runs"`
+}
+
+// Config controls how a Server broadcasts messages.
+type Config struct {
+	// BatchInterval is the longest a new message waits before it is sent on
+	// to neighbours, which bounds latency. See batcher for how long it
+	// actually waits.
+	BatchInterval time.Duration
+
+	// MaxBatchSize is how many new messages are sent on at once, without
+	// waiting any longer. Zero means there is no limit.
+	MaxBatchSize int
+
+	// TargetBatchSize is how many messages a batch waits to collect under
+	// heavy load, when they arrive fast enough to fill it within
+	// BatchInterval. Every batch costs about two messages per edge of the
+	// tree, so this is what keeps the messages per operation within budget.
+	// Under lighter load a batch is sent straight away. One turns batching
+	// off.
+	TargetBatchSize int
+
+	// IdleTimeout is how long a batch waits for another message before it is
+	// sent anyway. Zero turns it off.
+	IdleTimeout time.Duration
+
+	// Overlay is the graph messages are broadcast over.
+	Overlay Overlay
+
+	// Fanout is how many children each node has when Overlay is OverlayTree
+	// or OverlayPlumtree.
+	Fanout int
+
+	// LazyFanout is how many peers outside the tree a node announces messages
+	// to when Overlay is OverlayPlumtree.
+	LazyFanout int
+
+	// GossipFanout is how many random peers a node exchanges messages with
+	// each round when Overlay is OverlayGossip.
+	GossipFanout int
+
+	// GossipInterval is how often a node starts a round of gossip when
+	// Overlay is OverlayGossip.
+	GossipInterval time.Duration
+
+	// GraftTimeout is how long a node waits for a message it has been
+	// announced to arrive through the tree, before grafting the announcer
+	// onto the tree to fetch it.
+	GraftTimeout time.Duration
+
+	// SuspectTimeout is how long a neighbour may take to acknowledge a batch
+	// before it counts as a timeout. Zero turns off routing around
+	// unresponsive neighbours.
+	SuspectTimeout time.Duration
+
+	// SuspectAfter is how many timeouts in a row make a neighbour suspected,
+	// at which point batches are also sent to its own neighbours.
+	SuspectAfter int
+
+	// DeliveryTimeout is how long a batch is retried before it is merged with
+	// the messages queued for the same neighbour since, and sent again as one
+	// batch. Zero means a batch is retried until it is acknowledged.
+	DeliveryTimeout time.Duration
+
+	// AntiEntropyInterval is how often the node reconciles its messages with
+	// a neighbour. Zero turns anti-entropy off.
+	AntiEntropyInterval time.Duration
+}
+
+// DefaultConfig returns the configuration used for challenge 3e.
+func DefaultConfig() Config {
+	return Config{
+		BatchInterval:   700 * time.Millisecond,
+		MaxBatchSize:    100,
+		TargetBatchSize: 2,
+		IdleTimeout:     500 * time.Millisecond,
+
+		Overlay: OverlayTree,
+		Fanout:  4,
+
+		LazyFanout:   2,
+		GraftTimeout: time.Second,
+
+		GossipFanout:   3,
+		GossipInterval: 200 * time.Millisecond,
+
+		SuspectTimeout: 2 * time.Second,
+		SuspectAfter:   2,
+
+		DeliveryTimeout:     5 * time.Second,
+		AntiEntropyInterval: time.Second,
+	}
+}
+
+// Server is a node in the cluster.
+type Server struct {
+	cfg          Config
+	n            *maelstrom.Node
+	sender       *retry.Sender
+	metrics      *metrics.Registry
+	messages     map[int]struct{}
+	seen         []int
+	messagesLock *sync.RWMutex
+	messagesChan chan int
+
+	// The overlay is read by the background batcher, so it needs its own
+	// lock. graph and neighbours are nil until the topology message arrives,
+	// or in tree mode until the first message after the node is initialised.
+	id             string
+	graph          map[string][]string
+	neighbours     []string
+	timeouts       map[string]int
+	suspected      map[string]bool
+	lazy           []string
+	peers          []string
+	neighboursLock *sync.RWMutex
+
+	// Plumtree's messages to announce to lazy peers at the next batch, and
+	// the announced messages it is waiting for.
+	announce     []int
+	missing      map[int]bool
+	plumtreeLock *sync.Mutex
+
+	// Messages waiting to be sent to each neighbour, see outbox.
+	outboxes   map[string]*outbox
+	outboxLock *sync.Mutex
+
+	// Gossip's messages to push in the next round.
+	rumours    []int
+	gossipLock *sync.Mutex
+}
+
+// NewServer returns a Server handling messages for n. It starts background
+// goroutines which send batches of messages and reconcile with neighbours for
+// as long as the process runs.
+func NewServer(n *maelstrom.Node, cfg Config) *Server {
+	s := &Server{
+		cfg:          cfg,
+		n:            n,
+		sender:       retry.NewSender(n, retry.DefaultConfig()),
+		metrics:      metrics.For(n),
+		messages:     make(map[int]struct{}),
+		messagesLock: &sync.RWMutex{},
+		messagesChan: make(chan int, 100),
+
+		timeouts:       make(map[string]int),
+		suspected:      make(map[string]bool),
+		neighboursLock: &sync.RWMutex{},
+
+		missing:      make(map[int]bool),
+		plumtreeLock: &sync.Mutex{},
+
+		outboxes:   make(map[string]*outbox),
+		outboxLock: &sync.Mutex{},
+
+		gossipLock: &sync.Mutex{},
+	}
+
+	rpc.Handle(n, "broadcast", s.broadcast)
+	rpc.Handle(n, "broadcast_batch", s.broadcastBatch)
+	rpc.Handle(n, "read", s.read)
+	rpc.Handle(n, "topology", s.topology)
+	rpc.Handle(n, "sync", s.sync)
+	rpc.Handle(n, "ihave", s.ihave)
+	rpc.Handle(n, "graft", s.graft)
+	rpc.Handle(n, "prune", s.prune)
+	rpc.Handle(n, "gossip", s.gossip)
+
+	health.Report(n, "messages", func() int {
+		s.messagesLock.RLock()
+		defer s.messagesLock.RUnlock()
+
+		return len(s.messages)
+	})
+
+	if cfg.Overlay == OverlayGossip {
+		go s.pushPull()
+	} else {
+		go s.batch()
+	}
+	if cfg.AntiEntropyInterval > 0 {
+		go s.antiEntropy()
+	}
+
+	return s
+}
+
+// Background goroutine that fetches messages from a channel and sends them
+// on in batches, whenever the batcher says a batch is due.
+//
+// It also wakes up every BatchInterval while there is nothing to send, so
+// that Plumtree's announcements go out even when the node only relays.
+func (s *Server) batch() {
+	b := newBatcher(s.cfg)
+	timer := time.NewTimer(s.cfg.BatchInterval)
+	defer timer.Stop()
+
+	for {
+		fired := false
+		select {
+		case msg := <-s.messagesChan:
+			b.add(msg, time.Now())
+		case <-timer.C:
+			fired = true
+		}
+
+		msgBatch := b.flush(time.Now())
+		if len(msgBatch) > 0 {
+			s.metrics.Counter("broadcast.batches").Inc()
+			s.metrics.Counter("broadcast.batched_messages").Add(int64(len(msgBatch)))
+			s.metrics.Gauge("broadcast.batch_delay_ms").Set(b.delay().Milliseconds())
+
+			for _, neighbour := range s.getNeighbours() {
+				s.send(neighbour, msgBatch)
+			}
+		}
+		if fired || len(msgBatch) > 0 {
+			s.lazyPush(msgBatch)
+		}
+
+		wait := s.cfg.BatchInterval
+		if due := b.deadline(); !due.IsZero() {
+			wait = time.Until(due)
+		}
+		if !fired && !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+func (s *Server) broadcast(ctx context.Context, req broadcastRequest) (struct{}, error) {
+	s.buildTree()
+
+	s.messagesLock.RLock()
+	_, exists := s.messages[req.Message]
+	s.messagesLock.RUnlock()
+
+	if !exists {
+		go func() {
+			s.messagesChan <- req.Message
+		}()
+	}
+
+	s.store([]int{req.Message})
+
+	return struct{}{}, nil
+}
+
+func (s *Server) broadcastBatch(ctx context.Context, req broadcastBatchRequest) (struct{}, error) {
+	msg := rpc.Message(ctx)
+	s.buildTree()
+
+	fresh := s.unseen(req.Message)
+	if s.cfg.Overlay == OverlayPlumtree {
+		s.eagerReceived(msg.Src, req.Message, fresh)
+	}
+
+	if len(fresh) > 0 {
+		s.relay(msg.Src, req.Message)
+	}
+
+	s.store(req.Message)
+
+	return struct{}{}, nil
+}
+
+// relay sends msgs on to every neighbour except src, which they came from.
+func (s *Server) relay(src string, msgs []int) {
+	s.metrics.Counter("broadcast.relayed_batches").Inc()
+	for _, neighbour := range s.getNeighbours() {
+		if neighbour == src {
+			continue
+		}
+		s.send(neighbour, msgs)
+	}
+}
+
+// unseen returns the messages in msgs which the node has not seen.
+func (s *Server) unseen(msgs []int) []int {
+	s.messagesLock.RLock()
+	defer s.messagesLock.RUnlock()
+
+	fresh := make([]int, 0)
+	for _, msg := range msgs {
+		if _, exists := s.messages[msg]; !exists {
+			fresh = append(fresh, msg)
+		}
+	}
+	return fresh
+}
+
+// store adds msgs to the messages the node has seen.
+func (s *Server) store(msgs []int) {
+	s.messagesLock.Lock()
+	defer s.messagesLock.Unlock()
+
+	for _, msg := range msgs {
+		if _, exists := s.messages[msg]; !exists {
+			s.messages[msg] = struct{}{}
+			s.seen = append(s.seen, msg)
+		}
+	}
+	s.metrics.Gauge("broadcast.messages").Set(int64(len(s.messages)))
+}
+
+func (s *Server) read(ctx context.Context, req readRequest) (readResponse, error) {
+	if req.Since == nil {
+		return readResponse{Messages: s.allMessages()}, nil
+	}
+
+	s.messagesLock.RLock()
+	defer s.messagesLock.RUnlock()
+
+	// the cursor is how many messages the node had seen, in the order it saw
+	// them
+	since := *req.Since
+	if since < 0 || since > len(s.seen) {
+		return readResponse{}, rpc.Errorf(maelstrom.MalformedRequest, "cursor %d is out of range", since)
+	}
+
+	cursor := len(s.seen)
+	return readResponse{Messages: slices.Clone(s.seen[since:]), Cursor: &cursor}, nil
+}
+
+// allMessages returns every message the node has seen.
+func (s *Server) allMessages() []int {
+	msgs := make([]int, 0)
+
+	s.messagesLock.RLock()
+	for msg := range s.messages {
+		msgs = append(msgs, msg)
+	}
+	s.messagesLock.RUnlock()
+
+	return msgs
+}
@@ -0,0 +1,263 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestBatchedBroadcastConverges(t *testing.T) {
+	net := sim.NewNetwork(sim.Config{Latency: 10 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	for _, id := range []string{"n0", "n1", "n2", "n3", "n4"} {
+		net.AddNode(id, NewServer(maelstrom.NewNode(), DefaultConfig()).n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	c := net.Client("c1")
+
+	topology := map[string][]string{
+		"n0": {"n1", "n2"},
+		"n1": {"n0", "n3", "n4"},
+		"n2": {"n0"},
+		"n3": {"n1"},
+		"n4": {"n1"},
+	}
+	for _, id := range net.NodeIDs() {
+		if _, err := c.RPC(ctx, id, map[string]any{"type": "topology", "topology": topology}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := make([]int, 0)
+	for i := 0; i < 20; i++ {
+		id := net.NodeIDs()[i%5]
+		if _, err := c.RPC(ctx, id, broadcastRequest{Type: "broadcast", Message: i}); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, i)
+	}
+
+	// Batches are flushed once a second and the tree is three hops deep.
+	time.Sleep(3500 * time.Millisecond)
+
+	for _, id := range net.NodeIDs() {
+		reply, err := c.RPC(ctx, id, map[string]any{"type": "read"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resp readResponse
+		if err := json.Unmarshal(reply.Body, &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		slices.Sort(resp.Messages)
+		if !slices.Equal(resp.Messages, expected) {
+			t.Errorf("%s: expected %v, got %v", id, expected, resp.Messages)
+		}
+	}
+}
+
+func TestTreeOverlayIgnoresSuppliedTopology(t *testing.T) {
+	servers := make([]*Server, 0)
+	net := sim.NewNetwork(sim.Config{Latency: time.Millisecond})
+	for _, id := range nodeIDs(9) {
+		s := NewServer(maelstrom.NewNode(), DefaultConfig())
+		net.AddNode(id, s.n)
+		servers = append(servers, s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	// maelstrom's default grid topology
+	grid := map[string][]string{
+		"n0": {"n3", "n1"}, "n1": {"n4", "n2", "n0"}, "n2": {"n5", "n1"},
+		"n3": {"n0", "n6", "n4"}, "n4": {"n1", "n7", "n3", "n5"}, "n5": {"n2", "n8", "n4"},
+		"n6": {"n3", "n7"}, "n7": {"n4", "n6", "n8"}, "n8": {"n5", "n7"},
+	}
+	c := net.Client("c1")
+	for _, id := range net.NodeIDs() {
+		if _, err := c.RPC(ctx, id, map[string]any{"type": "topology", "topology": grid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tree := Tree(net.NodeIDs(), DefaultConfig().Fanout)
+	for i, s := range servers {
+		id := net.NodeIDs()[i]
+		if neighbours := s.getNeighbours(); !slices.Equal(neighbours, tree[id]) {
+			t.Errorf("%s: expected tree neighbours %v, got %v", id, tree[id], neighbours)
+		}
+	}
+}
+
+// read returns the messages node id has seen, sorted.
+func read(t *testing.T, ctx context.Context, c *sim.Client, id string) []int {
+	t.Helper()
+
+	reply, err := c.RPC(ctx, id, readRequest{Type: "read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp readResponse
+	if err := json.Unmarshal(reply.Body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(resp.Messages)
+	return resp.Messages
+}
+
+// waitForAll waits until every node has seen expected.
+func waitForAll(t *testing.T, ctx context.Context, net *sim.Network, expected []int) {
+	t.Helper()
+
+	c := net.Client("c0")
+	for _, id := range net.NodeIDs() {
+		for {
+			got := read(t, ctx, c, id)
+			if slices.Equal(got, expected) {
+				break
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("%s: expected %v, got %v", id, expected, got)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestRoutesAroundPartitionedEdge(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Fanout = 2
+	cfg.BatchInterval = 20 * time.Millisecond
+	// generous, so that only the cut edge is suspected even on a loaded machine
+	cfg.SuspectTimeout = 500 * time.Millisecond
+	cfg.SuspectAfter = 1
+	// otherwise anti-entropy can deliver everything before anyone is suspected
+	cfg.AntiEntropyInterval = 0
+
+	// n0 is the root, with n1 and n2 below it, and n3 to n6 below those
+	net, servers, c, ctx := startCluster(t, cfg, 7)
+
+	// cut the n0-n1 edge, which leaves n1, n3 and n4 off the tree
+	nem := sim.NewNemesis(net, sim.NemesisConfig{})
+	nem.Apply(sim.Step{Fault: sim.PartitionIsolated, Groups: [][]string{{"n0"}, {"n1"}}})
+
+	for i, id := range []string{"n3", "n6", "n0"} {
+		if _, err := c.RPC(ctx, id, broadcastRequest{Type: "broadcast", Message: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForAll(t, ctx, net, []int{0, 1, 2})
+
+	// n1 sends to n2 in place of n0, and n0 to n3 and n4 in place of n1
+	if neighbours := servers["n1"].getNeighbours(); !slices.Contains(neighbours, "n2") {
+		t.Errorf("expected n1 to route around n0, got %v", neighbours)
+	}
+	if neighbours := servers["n0"].getNeighbours(); !slices.Contains(neighbours, "n3") || !slices.Contains(neighbours, "n4") {
+		t.Errorf("expected n0 to route around n1, got %v", neighbours)
+	}
+
+	// once the edge is back, both ends go back to the tree
+	nem.Apply(sim.Step{Fault: sim.Heal})
+	tree := Tree(net.NodeIDs(), cfg.Fanout)
+	for _, id := range []string{"n0", "n1"} {
+		for !slices.Equal(servers[id].getNeighbours(), tree[id]) {
+			if ctx.Err() != nil {
+				t.Fatalf("%s: expected tree neighbours %v, got %v", id, tree[id], servers[id].getNeighbours())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestReadSinceCursor(t *testing.T) {
+	s := NewServer(maelstrom.NewNode(), DefaultConfig())
+	net := sim.NewNetwork(sim.Config{})
+	net.AddNode("n0", s.n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	c := net.Client("c1")
+	broadcast := func(msgs ...int) {
+		t.Helper()
+		for _, msg := range msgs {
+			if _, err := c.RPC(ctx, "n0", broadcastRequest{Type: "broadcast", Message: msg}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	readSince := func(since int) readResponse {
+		t.Helper()
+		reply, err := c.RPC(ctx, "n0", readRequest{Type: "read", Since: &since})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp readResponse
+		if err := json.Unmarshal(reply.Body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Cursor == nil {
+			t.Fatalf("expected a cursor, got %s", reply.Body)
+		}
+		return resp
+	}
+
+	broadcast(3, 1, 2)
+	first := readSince(0)
+	if !slices.Equal(first.Messages, []int{3, 1, 2}) || *first.Cursor != 3 {
+		t.Errorf("expected [3 1 2] up to cursor 3, got %v up to %d", first.Messages, *first.Cursor)
+	}
+
+	// duplicates are not new
+	broadcast(7, 1)
+	second := readSince(*first.Cursor)
+	if !slices.Equal(second.Messages, []int{7}) || *second.Cursor != 4 {
+		t.Errorf("expected [7] up to cursor 4, got %v up to %d", second.Messages, *second.Cursor)
+	}
+
+	// a plain read is the standard read_ok
+	reply, err := c.RPC(ctx, "n0", readRequest{Type: "read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(reply.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := body["cursor"]; ok {
+		t.Errorf("expected no cursor in a plain read, got %s", reply.Body)
+	}
+
+	since := 5
+	_, err = c.RPC(ctx, "n0", readRequest{Type: "read", Since: &since})
+	if code := maelstrom.ErrorCode(err); code != maelstrom.MalformedRequest {
+		t.Errorf("expected a malformed request error for a cursor past the end, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+// Package counter implements the grow-only counter challenge.
+package counter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/retry"
+	"fly-io-dist-sys/internal/rpc"
+)
+
+const counterKey string = "counter"
+
+// Server is a node in the cluster.
+type Server struct {
+	n       *maelstrom.Node
+	kv      *maelstrom.KV
+	sender  *retry.Sender
+	metrics *metrics.Registry
+	c       int
+	cMu     *sync.Mutex
+
+	// IDs of adds already counted, so that an add which is delivered again
+	// after its acknowledgement was lost is not counted twice.
+	seen map[string]struct{}
+}
+
+// NewServer returns a Server handling messages for n.
+func NewServer(n *maelstrom.Node) *Server {
+	s := &Server{
+		n:       n,
+		kv:      maelstrom.NewSeqKV(n),
+		sender:  retry.NewSender(n, retry.DefaultConfig()),
+		metrics: metrics.For(n),
+		c:       0,
+		cMu:     &sync.Mutex{},
+		seen:    make(map[string]struct{}),
+	}
+
+	rpc.Handle(n, "add", s.add)
+	rpc.Handle(n, "read", s.read)
+
+	return s
+}
+
+func (s *Server) add(ctx context.Context, body addRequest) (struct{}, error) {
+	msg := rpc.Message(ctx)
+
+	// adds from clients are given an ID before they are broadcast
+	if isClientMsg(msg) {
+		body.ID = fmt.Sprintf("%s-%d", msg.Src, body.MsgID)
+	}
+
+	s.cMu.Lock()
+	if _, ok := s.seen[body.ID]; ok {
+		s.cMu.Unlock()
+		s.metrics.Counter("counter.duplicate_adds").Inc()
+		return struct{}{}, nil
+	}
+	s.seen[body.ID] = struct{}{}
+
+	start := time.Now()
+	err := s.kv.CompareAndSwap(ctx, counterKey, s.c, s.c+body.Delta, true)
+	s.metrics.Histogram("counter.cas_latency").Since(start)
+	s.metrics.Counter("counter.cas_attempts").Inc()
+
+	s.c += body.Delta
+	s.metrics.Gauge("counter.value").Set(int64(s.c))
+	s.cMu.Unlock()
+
+	// broadcast add to other nodes if message comes from client
+	if isClientMsg(msg) {
+		for _, nId := range s.n.NodeIDs() {
+			if nId == s.n.ID() {
+				continue
+			}
+
+			s.sender.Send(context.Background(), nId, body, nil)
+		}
+	}
+
+	// A rejected compare-and-swap only means another node has written the
+	// counter since this one last did. Any other failure must not be reported
+	// as a definite one, since the add has already been counted and sent on.
+	if err != nil {
+		code := maelstrom.ErrorCode(err)
+		if code != maelstrom.KeyDoesNotExist && code != maelstrom.PreconditionFailed {
+			s.metrics.Counter("counter.cas_errors").Inc()
+			return struct{}{}, rpc.Errorf(maelstrom.Crash, "write counter: %v", err)
+		}
+		s.metrics.Counter("counter.cas_conflicts").Inc()
+	}
+
+	return struct{}{}, nil
+}
+
+func isClientMsg(msg maelstrom.Message) bool {
+	return strings.HasPrefix(msg.Src, "c")
+}
+
+func (s *Server) read(ctx context.Context, req readRequest) (readResponse, error) {
+	n, err := s.kv.ReadInt(ctx, counterKey)
+	if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+		// nothing has been added yet
+		return readResponse{Value: 0}, nil
+	}
+	if err != nil {
+		return readResponse{}, rpc.Errorf(maelstrom.TemporarilyUnavailable, "read counter: %v", err)
+	}
+
+	return readResponse{Value: n}, nil
+}
+
+type readRequest struct {
+	Type string `json:"type"`
+}
+
+type readResponse struct {
+	Value int `json:"value"`
+}
+
+type addRequest struct {
+	Type  string `json:"type"`
+	MsgID int    `json:"msg_id,omitempty"`
+	Delta int    `json:"delta"`
+	ID    string `json:"id,omitempty"`
+}
@@ -0,0 +1,152 @@
+package counter
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestCounterConvergesWithStaleReads(t *testing.T) {
+	net := sim.NewNetwork(sim.Config{Latency: 2 * time.Millisecond, Jitter: 5 * time.Millisecond})
+	net.AddService(maelstrom.SeqKV, sim.NewKV(maelstrom.SeqKV, sim.KVConfig{StaleReads: 0.5, Seed: 4}))
+	for _, id := range []string{"n0", "n1", "n2"} {
+		net.AddNode(id, NewServer(maelstrom.NewNode()).n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	c := net.Client("c1")
+
+	total := 0
+	for i := 1; i <= 30; i++ {
+		id := net.NodeIDs()[i%3]
+		if _, err := c.RPC(ctx, id, addRequest{Type: "add", Delta: i}); err != nil {
+			t.Fatal(err)
+		}
+		total += i
+	}
+
+	// Reads may be stale, but every node should eventually see the total.
+	for _, id := range net.NodeIDs() {
+		for {
+			reply, err := c.RPC(ctx, id, readRequest{Type: "read"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var resp readResponse
+			if err := json.Unmarshal(reply.Body, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Value > total {
+				t.Fatalf("%s: read %d, more than the %d that was added", id, resp.Value, total)
+			}
+			if resp.Value == total {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestCounterConvergesAfterPartitions(t *testing.T) {
+	const seed = 20240408
+
+	net := sim.NewNetwork(sim.Config{Latency: 2 * time.Millisecond, Seed: seed})
+	net.AddService(maelstrom.SeqKV, sim.NewKV(maelstrom.SeqKV, sim.KVConfig{StaleReads: 0.2, Seed: seed}))
+	for _, id := range []string{"n0", "n1", "n2"} {
+		net.AddNode(id, NewServer(maelstrom.NewNode()).n)
+	}
+
+	nem := sim.NewNemesis(net, sim.NemesisConfig{
+		Seed:     seed,
+		Faults:   []sim.Fault{sim.PartitionMajority, sim.PartitionIsolated},
+		Interval: 50 * time.Millisecond,
+		DropRate: 0.1,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	faults, stopFaults := context.WithCancel(ctx)
+	go nem.Run(faults)
+
+	c := net.Client("c1")
+
+	total := 0
+	for i := 1; i <= 30; i++ {
+		id := net.NodeIDs()[i%3]
+		if _, err := c.RPC(ctx, id, addRequest{Type: "add", Delta: i}); err != nil {
+			t.Fatal(err)
+		}
+		total += i
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopFaults()
+
+	for _, id := range net.NodeIDs() {
+		for {
+			reply, err := c.RPC(ctx, id, readRequest{Type: "read"})
+			if err != nil {
+				t.Fatalf("%v (nemesis schedule: %v)", err, nem.History())
+			}
+
+			var resp readResponse
+			if err := json.Unmarshal(reply.Body, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Value == total {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestReadBeforeAnyAdd(t *testing.T) {
+	net := sim.NewNetwork(sim.Config{})
+	net.AddService(maelstrom.SeqKV, sim.NewKV(maelstrom.SeqKV, sim.KVConfig{}))
+	net.AddNode("n0", NewServer(maelstrom.NewNode()).n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	// The counter has never been written, which is a zero count rather than
+	// an error.
+	reply, err := net.Client("c1").RPC(ctx, "n0", readRequest{Type: "read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp readResponse
+	if err := json.Unmarshal(reply.Body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Value != 0 {
+		t.Errorf("expected 0, got %d", resp.Value)
+	}
+}
@@ -0,0 +1,169 @@
+// Package echo implements the echo challenge.
+//
+// Beyond echoing the payload back, a node reports when it received the request
+// and can pass it on through a chain of peers given in echo_via, which makes
+// it a diagnostic for latency and routing between nodes. Every other field of
+// the request is echoed back unchanged.
+package echo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/retry"
+	"fly-io-dist-sys/internal/rpc"
+)
+
+const (
+	// maxVia is the most peers an echo may be passed through.
+	maxVia = 64
+
+	// forwardTimeout is how long a node waits for the rest of the chain to
+	// reply to a forwarded echo.
+	forwardTimeout = 5 * time.Second
+)
+
+type echoRequest struct {
+	Type string `json:"type"`
+	Echo any    `json:"echo"`
+
+	// Via are the nodes to pass the echo through, in order, before it is sent
+	// back.
+	Via []string `json:"echo_via,omitempty"`
+
+	// Hops is how many times the echo has already been passed on.
+	Hops int `json:"hops,omitempty"`
+}
+
+type echoResponse struct {
+	Echo any `json:"echo"`
+	report
+}
+
+// report is what a node adds to the request it echoes back.
+type report struct {
+	// NodeID and ReceivedAt describe the node which answered the request.
+	NodeID     string `json:"node_id"`
+	ReceivedAt int64  `json:"received_at_us"`
+
+	// Hops is how many times the echo was passed from one node to the next.
+	Hops int `json:"hops"`
+
+	// Trace lists every node the echo went through, starting with this one.
+	Trace []hop `json:"trace"`
+}
+
+// hop is one node's part in an echo.
+type hop struct {
+	Node string `json:"node"`
+
+	// ReceivedAt is when the node received the echo, in microseconds since
+	// the Unix epoch. Nodes in a maelstrom test share a clock, so subtracting
+	// consecutive hops gives the latency between them.
+	ReceivedAt int64 `json:"received_at_us"`
+}
+
+// Server is a node in the cluster.
+type Server struct {
+	n      *maelstrom.Node
+	sender *retry.Sender
+}
+
+// NewServer returns a Server handling messages for n.
+func NewServer(n *maelstrom.Node) *Server {
+	s := &Server{
+		n:      n,
+		sender: retry.NewSender(n, retry.DefaultConfig()),
+	}
+
+	rpc.Handle(n, "echo", s.echo)
+
+	return s
+}
+
+func (s *Server) echo(ctx context.Context, req echoRequest) (map[string]json.RawMessage, error) {
+	received := time.Now().UnixMicro()
+
+	// Echo the whole request back, including fields we know nothing about.
+	// Its type is dropped so that the reply type is filled in for us.
+	body := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(rpc.Message(ctx).Body, &body); err != nil {
+		return nil, err
+	}
+	delete(body, "type")
+
+	r := report{
+		NodeID:     s.n.ID(),
+		ReceivedAt: received,
+		Hops:       req.Hops,
+		Trace:      []hop{{Node: s.n.ID(), ReceivedAt: received}},
+	}
+
+	if len(req.Via) > maxVia {
+		return nil, rpc.Errorf(maelstrom.MalformedRequest, "echo_via has %d nodes, at most %d are allowed", len(req.Via), maxVia)
+	}
+	for _, id := range req.Via {
+		if !slices.Contains(s.n.NodeIDs(), id) {
+			return nil, rpc.Errorf(maelstrom.MalformedRequest, "echo_via: unknown node %q", id)
+		}
+	}
+
+	if len(req.Via) > 0 {
+		next, err := s.forward(ctx, body, req)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Hops = next.Hops
+		r.Trace = append(r.Trace, next.Trace...)
+	}
+
+	if err := merge(body, r); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// forward passes the echo, with the rest of its fields, on to the first node
+// in req.Via, and returns its reply once the rest of the chain has answered.
+func (s *Server) forward(ctx context.Context, fields map[string]json.RawMessage, req echoRequest) (report, error) {
+	ctx, cancel := context.WithTimeout(ctx, forwardTimeout)
+	defer cancel()
+
+	dest := req.Via[0]
+	body := maps.Clone(fields)
+	delete(body, "echo_via")
+	if err := merge(body, struct {
+		Type string   `json:"type"`
+		Via  []string `json:"echo_via,omitempty"`
+		Hops int      `json:"hops"`
+	}{"echo", req.Via[1:], req.Hops + 1}); err != nil {
+		return report{}, err
+	}
+
+	msg, err := s.sender.SendSync(ctx, dest, body)
+	if err != nil {
+		return report{}, fmt.Errorf("forward echo to %s: %w", dest, err)
+	}
+
+	var resp report
+	if err := json.Unmarshal(msg.Body, &resp); err != nil {
+		return report{}, fmt.Errorf("forward echo to %s: %w", dest, err)
+	}
+	return resp, nil
+}
+
+// merge sets the fields of body to those v encodes to, leaving the rest alone.
+func merge(body map[string]json.RawMessage, v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, &body)
+}
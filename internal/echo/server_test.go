@@ -0,0 +1,130 @@
+package echo
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func startCluster(t *testing.T, cfg sim.Config) (context.Context, *sim.Network) {
+	t.Helper()
+
+	net := sim.NewNetwork(cfg)
+	for _, id := range []string{"n0", "n1", "n2"} {
+		net.AddNode(id, NewServer(maelstrom.NewNode()).n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(net.Close)
+
+	return ctx, net
+}
+
+func TestEcho(t *testing.T) {
+	ctx, net := startCluster(t, sim.Config{})
+
+	reply, err := net.Client("c1").RPC(ctx, "n1", echoRequest{Type: "echo", Echo: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp echoResponse
+	if err := json.Unmarshal(reply.Body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Echo != "hello" || resp.NodeID != "n1" || resp.Hops != 0 || len(resp.Trace) != 1 {
+		t.Fatalf("unexpected reply %+v", resp)
+	}
+	if resp.ReceivedAt == 0 || resp.Trace[0].ReceivedAt != resp.ReceivedAt {
+		t.Fatalf("expected a receive timestamp, got %+v", resp)
+	}
+}
+
+func TestEchoVia(t *testing.T) {
+	latency := 10 * time.Millisecond
+	ctx, net := startCluster(t, sim.Config{Latency: latency})
+
+	// the chain may go back through nodes it has already visited
+	via := []string{"n1", "n2", "n0"}
+	reply, err := net.Client("c1").RPC(ctx, "n0", echoRequest{Type: "echo", Echo: 42, Via: via})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp echoResponse
+	if err := json.Unmarshal(reply.Body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Echo != float64(42) || resp.NodeID != "n0" || resp.Hops != 3 {
+		t.Fatalf("unexpected reply %+v", resp)
+	}
+
+	expected := append([]string{"n0"}, via...)
+	if len(resp.Trace) != len(expected) {
+		t.Fatalf("expected a trace through %v, got %+v", expected, resp.Trace)
+	}
+	for i, h := range resp.Trace {
+		if h.Node != expected[i] {
+			t.Fatalf("expected a trace through %v, got %+v", expected, resp.Trace)
+		}
+		if i == 0 {
+			continue
+		}
+		if d := time.Duration(h.ReceivedAt-resp.Trace[i-1].ReceivedAt) * time.Microsecond; d < latency {
+			t.Errorf("expected hop %d to take at least %v, took %v", i, latency, d)
+		}
+	}
+}
+
+func TestEchoViaUnknownNode(t *testing.T) {
+	ctx, net := startCluster(t, sim.Config{})
+
+	_, err := net.Client("c1").RPC(ctx, "n0", echoRequest{Type: "echo", Echo: 1, Via: []string{"n1", "n9"}})
+	if code := maelstrom.ErrorCode(err); code != maelstrom.MalformedRequest {
+		t.Fatalf("expected malformed request, got %v", err)
+	}
+}
+
+func TestEchoPassesThroughFields(t *testing.T) {
+	ctx, net := startCluster(t, sim.Config{})
+
+	// fields the node knows nothing about come back unchanged, even once the
+	// echo has been passed along a chain
+	for _, via := range [][]string{nil, {"n1", "n2"}} {
+		reply, err := net.Client("c1").RPC(ctx, "n0", map[string]any{
+			"type":     "echo",
+			"echo":     "hello",
+			"echo_via": via,
+			"tag":      json.RawMessage(`{"id":9007199254740993}`),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resp struct {
+			Type string          `json:"type"`
+			Echo string          `json:"echo"`
+			Tag  json.RawMessage `json:"tag"`
+			Hops int             `json:"hops"`
+		}
+		if err := json.Unmarshal(reply.Body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Type != "echo_ok" || resp.Echo != "hello" || resp.Hops != len(via) {
+			t.Fatalf("unexpected reply %s", reply.Body)
+		}
+		if string(resp.Tag) != `{"id":9007199254740993}` {
+			t.Fatalf("expected the tag to be echoed back, got %s", reply.Body)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Package health lets a node be asked whether it can reach its peers.
+//
+// A "health" request makes the node ping every other node in the cluster and
+// reply with who answered and how quickly, along with the size of whatever
+// state the node keeps, as reported by the servers running on it:
+//
+//	health.Report(n, "messages", func() int { return len(s.messages) })
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/rpc"
+)
+
+// DefaultTimeout is how long a node waits for each peer to answer a ping, if
+// the health request does not say.
+const DefaultTimeout = time.Second
+
+var (
+	reporters   = make(map[*maelstrom.Node]map[string]func() int)
+	reportersMu sync.Mutex
+)
+
+// Report registers size as the way to measure the state called name on n. It
+// is called for every health request, so it must be safe to call at any time.
+func Report(n *maelstrom.Node, name string, size func() int) {
+	reportersMu.Lock()
+	defer reportersMu.Unlock()
+
+	if reporters[n] == nil {
+		reporters[n] = make(map[string]func() int)
+	}
+	reporters[n][name] = size
+}
+
+// Handle registers the "health" RPC on n, and the "ping" RPC it sends to
+// other nodes.
+func Handle(n *maelstrom.Node) {
+	rpc.Handle(n, "ping", func(ctx context.Context, req pingRequest) (struct{}, error) {
+		return struct{}{}, nil
+	})
+	rpc.Handle(n, "health", func(ctx context.Context, req healthRequest) (healthResponse, error) {
+		timeout := DefaultTimeout
+		if req.TimeoutMS > 0 {
+			timeout = time.Duration(req.TimeoutMS) * time.Millisecond
+		}
+
+		return healthResponse{
+			NodeID: n.ID(),
+			Peers:  pingAll(n, timeout),
+			State:  state(n),
+		}, nil
+	})
+}
+
+type pingRequest struct {
+	Type string `json:"type"`
+}
+
+type healthRequest struct {
+	Type string `json:"type"`
+
+	// TimeoutMS is how long to wait for each peer, in milliseconds.
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+}
+
+type healthResponse struct {
+	NodeID string          `json:"node_id"`
+	Peers  map[string]Peer `json:"peers"`
+	State  map[string]int  `json:"state"`
+}
+
+// Peer is what a node found out about one of its peers.
+type Peer struct {
+	Reachable bool    `json:"reachable"`
+	RTTMS     float64 `json:"rtt_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// pingAll pings every other node at once, and waits for them all to answer or
+// time out.
+func pingAll(n *maelstrom.Node, timeout time.Duration) map[string]Peer {
+	peers := make(map[string]Peer)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, id := range n.NodeIDs() {
+		if id == n.ID() {
+			continue
+		}
+
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			p := ping(n, id, timeout)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			peers[id] = p
+		}(id)
+	}
+
+	wg.Wait()
+	return peers
+}
+
+func ping(n *maelstrom.Node, id string, timeout time.Duration) Peer {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// buffered, so that a reply arriving after the timeout does not block the
+	// node's callback forever
+	replies := make(chan maelstrom.Message, 1)
+
+	start := time.Now()
+	err := n.RPC(id, pingRequest{Type: "ping"}, func(msg maelstrom.Message) error {
+		replies <- msg
+		return nil
+	})
+	if err != nil {
+		return Peer{Error: err.Error()}
+	}
+
+	select {
+	case <-ctx.Done():
+		return Peer{Error: "timed out after " + timeout.String()}
+	case msg := <-replies:
+		if err := msg.RPCError(); err != nil {
+			return Peer{Error: err.Error()}
+		}
+		return Peer{Reachable: true, RTTMS: float64(time.Since(start)) / float64(time.Millisecond)}
+	}
+}
+
+// state measures everything reported on n.
+func state(n *maelstrom.Node) map[string]int {
+	reportersMu.Lock()
+	sizes := make(map[string]func() int, len(reporters[n]))
+	for name, size := range reporters[n] {
+		sizes[name] = size
+	}
+	reportersMu.Unlock()
+
+	// measured without the lock held, since a server may take its own locks
+	s := make(map[string]int, len(sizes))
+	for name, size := range sizes {
+		s[name] = size()
+	}
+	return s
+}
@@ -0,0 +1,62 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestHealth(t *testing.T) {
+	latency := 5 * time.Millisecond
+	net := sim.NewNetwork(sim.Config{Latency: latency})
+	for _, id := range []string{"n0", "n1", "n2"} {
+		n := maelstrom.NewNode()
+		Handle(n)
+		net.AddNode(id, n)
+
+		if id == "n0" {
+			Report(n, "messages", func() int { return 3 })
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	net.Kill("n2")
+
+	reply, err := net.Client("c1").RPC(ctx, "n0", healthRequest{Type: "health", TimeoutMS: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp healthResponse
+	if err := json.Unmarshal(reply.Body, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.NodeID != "n0" {
+		t.Errorf("expected n0, got %s", resp.NodeID)
+	}
+	if len(resp.Peers) != 2 {
+		t.Fatalf("expected 2 peers, got %v", resp.Peers)
+	}
+	if p := resp.Peers["n1"]; !p.Reachable || p.RTTMS < float64(2*latency/time.Millisecond) {
+		t.Errorf("expected n1 to be reachable in at least %v, got %+v", 2*latency, p)
+	}
+	if p := resp.Peers["n2"]; p.Reachable || p.Error == "" {
+		t.Errorf("expected n2 to be unreachable, got %+v", p)
+	}
+	if resp.State["messages"] != 3 {
+		t.Errorf("expected 3 messages, got %v", resp.State)
+	}
+}
@@ -0,0 +1,428 @@
+// Package kafka implements the Kafka-style log challenge, with writes
+// forwarded to a primary node.
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/health"
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/retry"
+	"fly-io-dist-sys/internal/rpc"
+)
+
+// Config controls which node a Server treats as the primary.
+type Config struct {
+	// Primary is the ID of the node which performs all writes.
+	Primary string
+
+	// ForwardTimeout is how long a secondary waits for the primary to
+	// acknowledge a forwarded write before giving up on it.
+	ForwardTimeout time.Duration
+
+	// KVTimeout is how long the primary waits for the KV stores while writing.
+	// It holds a lock meanwhile, so a lost reply must not stall every later
+	// write. It should be shorter than ForwardTimeout, so that secondaries
+	// hear the outcome.
+	KVTimeout time.Duration
+}
+
+// DefaultConfig returns the configuration used for challenge 5c.
+func DefaultConfig() Config {
+	return Config{
+		Primary:        "n0",
+		ForwardTimeout: 2 * time.Second,
+		KVTimeout:      time.Second,
+	}
+}
+
+// Server is a node in the cluster.
+//
+// The primary caches each log and committed offset it has written. A cached
+// value is dropped whenever a write to the KV store fails, since the store may
+// or may not have applied it, and is read back from the store on next use.
+type Server struct {
+	cfg         Config
+	n           *maelstrom.Node
+	linKV       *maelstrom.KV
+	seqKV       *maelstrom.KV
+	sender      *retry.Sender
+	metrics     *metrics.Registry
+	log         map[string][]int
+	logMu       *sync.RWMutex
+	committed   map[string]int
+	committedMu *sync.RWMutex
+
+	// Offsets of sends already appended, by ID, so that a send which the
+	// secondary forwards again after a lost reply is not appended twice. A
+	// send is only forwarded again within ForwardTimeout, so sentOrder is used
+	// to forget them once twice that has passed, which leaves room for
+	// duplicates delayed in the network.
+	sent      map[string]int
+	sentOrder []sentSend
+}
+
+// sentSend records when the send with the given ID was appended.
+type sentSend struct {
+	id string
+	at time.Time
+}
+
+// NewServer returns a Server handling messages for n.
+func NewServer(n *maelstrom.Node, cfg Config) *Server {
+	linKV := maelstrom.NewLinKV(n)
+	seqKV := maelstrom.NewSeqKV(n)
+	s := &Server{
+		cfg:         cfg,
+		n:           n,
+		linKV:       linKV,
+		seqKV:       seqKV,
+		sender:      retry.NewSender(n, retry.DefaultConfig()),
+		metrics:     metrics.For(n),
+		log:         make(map[string][]int),
+		logMu:       &sync.RWMutex{},
+		committed:   make(map[string]int),
+		committedMu: &sync.RWMutex{},
+		sent:        make(map[string]int),
+	}
+
+	rpc.Handle(n, "send", s.send)
+	rpc.Handle(n, "poll", s.poll)
+	rpc.Handle(n, "commit_offsets", s.commitOffsets)
+	rpc.Handle(n, "list_committed_offsets", s.listCommittedOffsets)
+
+	// only the primary caches logs, so these are zero on secondaries
+	health.Report(n, "logs", func() int {
+		s.logMu.RLock()
+		defer s.logMu.RUnlock()
+
+		return len(s.log)
+	})
+	health.Report(n, "log_entries", func() int {
+		s.logMu.RLock()
+		defer s.logMu.RUnlock()
+
+		entries := 0
+		for _, l := range s.log {
+			entries += len(l)
+		}
+		return entries
+	})
+
+	return s
+}
+
+func (s *Server) send(ctx context.Context, body sendRequest) (sendResponse, error) {
+	// sends from clients are given an ID before they are forwarded
+	if msg := rpc.Message(ctx); isClientMsg(msg) {
+		body.ID = fmt.Sprintf("%s-%d", msg.Src, body.MsgID)
+	}
+
+	if s.isPrimary() {
+		ctx, cancel := context.WithTimeout(ctx, s.cfg.KVTimeout)
+		defer cancel()
+
+		// update local state and write to kv store
+		s.logMu.Lock()
+		defer s.logMu.Unlock()
+
+		s.forgetSent(time.Now())
+		if offset, ok := s.sent[body.ID]; ok {
+			s.metrics.Counter("kafka.duplicate_sends").Inc()
+			return sendResponse{Offset: offset}, nil
+		}
+
+		oldLogs, err := s.loadLog(ctx, body.Key)
+		if err != nil {
+			return sendResponse{}, unavailable(err, "read log %s", body.Key)
+		}
+
+		logs := append(oldLogs[:len(oldLogs):len(oldLogs)], body.Message)
+		offset := len(logs) - 1
+
+		if err := s.linKV.CompareAndSwap(ctx, body.Key, oldLogs, logs, true); err != nil {
+			s.casFailed(err)
+			delete(s.log, body.Key)
+			return sendResponse{}, writeError(err, "append to log %s", body.Key)
+		}
+
+		s.metrics.Counter("kafka.appends").Inc()
+		s.log[body.Key] = logs
+		s.sent[body.ID] = offset
+		s.sentOrder = append(s.sentOrder, sentSend{id: body.ID, at: time.Now()})
+
+		return sendResponse{Offset: offset}, nil
+	} else {
+		// ask primary to update kv store and return response
+		m, err := s.forward(ctx, body)
+		if err != nil {
+			return sendResponse{}, err
+		}
+
+		var resp sendResponse
+		if err := json.Unmarshal(m.Body, &resp); err != nil {
+			return sendResponse{}, rpc.Errorf(maelstrom.Crash, "decode reply from primary: %v", err)
+		}
+		return resp, nil
+	}
+}
+
+func (s *Server) poll(ctx context.Context, body pollRequest) (pollResponse, error) {
+	polled := make(map[string][][]int)
+
+	// a read stuck under the lock would hold up every write behind it
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.KVTimeout)
+	defer cancel()
+
+	s.logMu.RLock()
+	defer s.logMu.RUnlock()
+
+	for key, offset := range body.Offsets {
+		messages := make([]int, 0)
+		if err := s.linKV.ReadInto(ctx, key, &messages); err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+			return pollResponse{}, unavailable(err, "read log %s", key)
+		}
+
+		// a client may poll past the end of a log it has not seen written
+		if offset > len(messages) {
+			offset = len(messages)
+		}
+		messages = messages[offset:]
+
+		o := offset
+		p := make([][]int, 0)
+
+		for _, m := range messages {
+			p = append(p, []int{o, m})
+			o++
+
+		}
+
+		polled[key] = p
+	}
+
+	return pollResponse{Messages: polled}, nil
+}
+
+func (s *Server) commitOffsets(ctx context.Context, body commitOffsetsRequest) (struct{}, error) {
+	if s.isPrimary() {
+		ctx, cancel := context.WithTimeout(ctx, s.cfg.KVTimeout)
+		defer cancel()
+
+		// update local state and write to kv store
+		s.committedMu.Lock()
+		defer s.committedMu.Unlock()
+
+		done := 0
+		for key, offset := range body.Offsets {
+			if err := s.commitOffset(ctx, key, offset); err != nil {
+				if done > 0 {
+					// earlier offsets in the request have already been
+					// committed, so it has been partly applied
+					return struct{}{}, rpc.Errorf(maelstrom.Crash, "%s", rpc.Error(err).Text)
+				}
+				return struct{}{}, err
+			}
+			done++
+		}
+	} else {
+		// ask primary to update kv store and return response
+		if _, err := s.forward(ctx, body); err != nil {
+			return struct{}{}, err
+		}
+	}
+
+	return struct{}{}, nil
+}
+
+func (s *Server) listCommittedOffsets(ctx context.Context, body listCommittedOffsetsRequest) (listCommittedOffsetsResponse, error) {
+	committed := make(map[string]int)
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.KVTimeout)
+	defer cancel()
+
+	s.committedMu.RLock()
+	defer s.committedMu.RUnlock()
+
+	for _, key := range body.Keys {
+		offset, err := s.seqKV.ReadInt(ctx, "committed-"+key)
+		if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+			// nothing has been committed for this key
+			continue
+		}
+		if err != nil {
+			return listCommittedOffsetsResponse{}, unavailable(err, "read committed offset %s", key)
+		}
+		committed[key] = offset
+	}
+
+	return listCommittedOffsetsResponse{Offsets: committed}, nil
+}
+
+// commitOffset writes the committed offset for key. The caller must hold
+// committedMu.
+func (s *Server) commitOffset(ctx context.Context, key string, offset int) error {
+	oldOffset, err := s.loadCommitted(ctx, key)
+	if err != nil {
+		return unavailable(err, "read committed offset %s", key)
+	}
+
+	if err := s.seqKV.CompareAndSwap(ctx, "committed-"+key, oldOffset, offset, true); err != nil {
+		s.casFailed(err)
+		delete(s.committed, key)
+		return writeError(err, "commit offset %s", key)
+	}
+
+	s.committed[key] = offset
+	return nil
+}
+
+// forgetSent drops the sends appended long enough before now that they can no
+// longer be forwarded again. The caller must hold logMu.
+func (s *Server) forgetSent(now time.Time) {
+	i := 0
+	for ; i < len(s.sentOrder) && now.Sub(s.sentOrder[i].at) > 2*s.cfg.ForwardTimeout; i++ {
+		delete(s.sent, s.sentOrder[i].id)
+	}
+	s.sentOrder = s.sentOrder[i:]
+}
+
+// loadLog returns the cached log for key, reading it from the KV store if it
+// is not cached. The caller must hold logMu.
+func (s *Server) loadLog(ctx context.Context, key string) ([]int, error) {
+	if logs, ok := s.log[key]; ok {
+		return logs, nil
+	}
+
+	logs := make([]int, 0)
+	if err := s.linKV.ReadInto(ctx, key, &logs); err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		return nil, err
+	}
+
+	s.log[key] = logs
+	return logs, nil
+}
+
+// loadCommitted returns the cached committed offset for key, reading it from
+// the KV store if it is not cached. The caller must hold committedMu.
+func (s *Server) loadCommitted(ctx context.Context, key string) (int, error) {
+	if offset, ok := s.committed[key]; ok {
+		return offset, nil
+	}
+
+	offset, err := s.seqKV.ReadInt(ctx, "committed-"+key)
+	if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		return 0, err
+	}
+
+	s.committed[key] = offset
+	return offset, nil
+}
+
+// forward sends a write on to the primary and waits for its reply.
+//
+// Error replies from the primary are passed back unchanged. If the primary
+// does not reply in time, the write may still have been applied, so the
+// client is told the outcome is unknown.
+func (s *Server) forward(ctx context.Context, body any) (maelstrom.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ForwardTimeout)
+	defer cancel()
+
+	s.metrics.Counter("kafka.forwarded").Inc()
+	start := time.Now()
+
+	m, err := s.sender.SendSync(ctx, s.getPrimary(), body)
+	s.metrics.Histogram("kafka.forward_latency").Since(start)
+	if err == nil {
+		return m, nil
+	}
+	s.metrics.Counter("kafka.forward_failures").Inc()
+
+	var rpcErr *maelstrom.RPCError
+	if errors.As(err, &rpcErr) {
+		return m, rpcErr
+	}
+	return m, rpc.Errorf(maelstrom.Crash, "no reply from primary %s: %v", s.getPrimary(), err)
+}
+
+// casFailed counts a failed compare-and-swap on the KV store.
+func (s *Server) casFailed(err error) {
+	if maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
+		s.metrics.Counter("kafka.cas_conflicts").Inc()
+	} else {
+		s.metrics.Counter("kafka.cas_errors").Inc()
+	}
+}
+
+// unavailable reports a failed read. Nothing has been changed, so the client
+// can safely try again.
+func unavailable(err error, format string, args ...any) error {
+	return rpc.Errorf(maelstrom.TemporarilyUnavailable, "%s: %v", fmt.Sprintf(format, args...), err)
+}
+
+// writeError reports a failed write to the KV store. A rejected
+// compare-and-swap was definitely not applied, but any other failure, such as
+// a timeout, may have been.
+func writeError(err error, format string, args ...any) error {
+	code := maelstrom.Crash
+	if maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
+		code = maelstrom.PreconditionFailed
+	}
+	return rpc.Errorf(code, "%s: %v", fmt.Sprintf(format, args...), err)
+}
+
+func isClientMsg(msg maelstrom.Message) bool {
+	return strings.HasPrefix(msg.Src, "c")
+}
+
+// A simple way to establish a primary node. The primary is fixed by the config.
+func (s *Server) isPrimary() bool {
+	return s.n.ID() == s.cfg.Primary
+}
+
+func (s *Server) getPrimary() string {
+	return s.cfg.Primary
+}
+
+type sendRequest struct {
+	Type    string `json:"type"`
+	MsgID   int    `json:"msg_id,omitempty"`
+	Key     string `json:"key"`
+	Message int    `json:"msg"`
+	ID      string `json:"id,omitempty"`
+}
+
+type sendResponse struct {
+	Offset int `json:"offset"`
+}
+
+type pollRequest struct {
+	Type    string         `json:"type"`
+	Offsets map[string]int `json:"offsets"`
+}
+
+type pollResponse struct {
+	Messages map[string][][]int `json:"msgs"`
+}
+
+type commitOffsetsRequest struct {
+	Type    string         `json:"type"`
+	Offsets map[string]int `json:"offsets"`
+}
+
+type listCommittedOffsetsRequest struct {
+	Type string   `json:"type"`
+	Keys []string `json:"keys"`
+}
+
+type listCommittedOffsetsResponse struct {
+	Offsets map[string]int `json:"offsets"`
+}
@@ -0,0 +1,326 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+// newCluster starts a primary n0 and a secondary n1.
+func newCluster(t *testing.T, cfg Config) (context.Context, *sim.Network, []*Server) {
+	t.Helper()
+
+	net := sim.NewNetwork(sim.Config{Latency: time.Millisecond})
+	net.AddService(maelstrom.LinKV, sim.NewKV(maelstrom.LinKV, sim.KVConfig{}))
+	net.AddService(maelstrom.SeqKV, sim.NewKV(maelstrom.SeqKV, sim.KVConfig{}))
+	servers := make([]*Server, 0)
+	for _, id := range []string{"n0", "n1"} {
+		s := NewServer(maelstrom.NewNode(), cfg)
+		net.AddNode(id, s.n)
+		servers = append(servers, s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(net.Close)
+
+	return ctx, net, servers
+}
+
+func TestSendThroughSecondary(t *testing.T) {
+	ctx, net, _ := newCluster(t, DefaultConfig())
+	c := net.Client("c1")
+
+	for i, m := range []int{10, 11, 12, 13} {
+		// Alternate between the primary and the secondary.
+		id := net.NodeIDs()[i%2]
+		reply, err := c.RPC(ctx, id, sendRequest{Type: "send", Key: "k1", Message: m})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resp sendResponse
+		if err := json.Unmarshal(reply.Body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Offset != i {
+			t.Errorf("expected offset %d, got %d", i, resp.Offset)
+		}
+	}
+
+	expected := map[string][][]int{"k1": {{2, 12}, {3, 13}}}
+	for _, id := range net.NodeIDs() {
+		reply, err := c.RPC(ctx, id, pollRequest{Type: "poll", Offsets: map[string]int{"k1": 2}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var polled pollResponse
+		if err := json.Unmarshal(reply.Body, &polled); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(polled.Messages, expected) {
+			t.Errorf("%s: expected %v, got %v", id, expected, polled.Messages)
+		}
+	}
+
+	if _, err := c.RPC(ctx, "n1", commitOffsetsRequest{Type: "commit_offsets", Offsets: map[string]int{"k1": 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range net.NodeIDs() {
+		reply, err := c.RPC(ctx, id, listCommittedOffsetsRequest{Type: "list_committed_offsets", Keys: []string{"k1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var committed listCommittedOffsetsResponse
+		if err := json.Unmarshal(reply.Body, &committed); err != nil {
+			t.Fatal(err)
+		}
+		if committed.Offsets["k1"] != 3 {
+			t.Errorf("%s: expected committed offset 3, got %v", id, committed.Offsets)
+		}
+	}
+}
+
+func TestUnreachablePrimary(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ForwardTimeout = 200 * time.Millisecond
+
+	ctx, net, _ := newCluster(t, cfg)
+	c := net.Client("c1")
+
+	nem := sim.NewNemesis(net, sim.NemesisConfig{})
+	nem.Apply(sim.Step{Fault: sim.PartitionIsolated, Groups: [][]string{{"n0"}, {"n1"}}})
+
+	// The primary may have received the write, so the outcome is unknown
+	// rather than a failure.
+	_, err := c.RPC(ctx, "n1", sendRequest{Type: "send", Key: "k1", Message: 10})
+	if code := maelstrom.ErrorCode(err); code != maelstrom.Crash {
+		t.Errorf("send: expected crash, got %v", err)
+	}
+
+	_, err = c.RPC(ctx, "n1", commitOffsetsRequest{Type: "commit_offsets", Offsets: map[string]int{"k1": 0}})
+	if code := maelstrom.ErrorCode(err); code != maelstrom.Crash {
+		t.Errorf("commit_offsets: expected crash, got %v", err)
+	}
+
+	nem.Apply(sim.Step{Fault: sim.Heal})
+
+	if _, err := c.RPC(ctx, "n1", sendRequest{Type: "send", Key: "k1", Message: 11}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConflictingWrite(t *testing.T) {
+	ctx, net, _ := newCluster(t, DefaultConfig())
+	c := net.Client("c1")
+
+	if _, err := c.RPC(ctx, "n0", sendRequest{Type: "send", Key: "k1", Message: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the log behind the primary's back, so its cached copy is stale.
+	write := map[string]any{"type": "write", "key": "k1", "value": []int{10, 98}}
+	if _, err := c.RPC(ctx, maelstrom.LinKV, write); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := c.RPC(ctx, "n0", sendRequest{Type: "send", Key: "k1", Message: 11})
+	if code := maelstrom.ErrorCode(err); code != maelstrom.PreconditionFailed {
+		t.Fatalf("expected precondition-failed, got %v", err)
+	}
+
+	// The rejected write is not retried, but the primary has caught up.
+	reply, err := c.RPC(ctx, "n0", sendRequest{Type: "send", Key: "k1", Message: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp sendResponse
+	if err := json.Unmarshal(reply.Body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Offset != 2 {
+		t.Errorf("expected offset 2, got %d", resp.Offset)
+	}
+}
+
+func TestPollAndListUnknownKeys(t *testing.T) {
+	ctx, net, _ := newCluster(t, DefaultConfig())
+	c := net.Client("c1")
+
+	if _, err := c.RPC(ctx, "n0", sendRequest{Type: "send", Key: "k1", Message: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := c.RPC(ctx, "n1", pollRequest{Type: "poll", Offsets: map[string]int{"k1": 5, "k2": 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var polled pollResponse
+	if err := json.Unmarshal(reply.Body, &polled); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][][]int{"k1": {}, "k2": {}}
+	if !reflect.DeepEqual(polled.Messages, expected) {
+		t.Errorf("expected %v, got %v", expected, polled.Messages)
+	}
+
+	reply, err = c.RPC(ctx, "n1", listCommittedOffsetsRequest{Type: "list_committed_offsets", Keys: []string{"k1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var committed listCommittedOffsetsResponse
+	if err := json.Unmarshal(reply.Body, &committed); err != nil {
+		t.Fatal(err)
+	}
+	if len(committed.Offsets) != 0 {
+		t.Errorf("expected no committed offsets, got %v", committed.Offsets)
+	}
+}
+
+func TestForwardedSendsAreNotDuplicated(t *testing.T) {
+	ctx, net, servers := newCluster(t, DefaultConfig())
+	c := net.Client("c1")
+
+	// Lost replies make the secondary send the same write again.
+	sim.NewNemesis(net, sim.NemesisConfig{Seed: 5, DropRate: 0.2, DuplicateRate: 0.3})
+
+	acked := make([]int, 0)
+	for m := 0; m < 10; m++ {
+		_, err := c.RPC(ctx, "n1", sendRequest{Type: "send", Key: "k1", Message: m})
+		if err == nil {
+			acked = append(acked, m)
+			continue
+		}
+		// a crash means the primary may or may not have appended it
+		if maelstrom.ErrorCode(err) != maelstrom.Crash {
+			t.Fatal(err)
+		}
+	}
+
+	reply, err := c.RPC(ctx, "n0", pollRequest{Type: "poll", Offsets: map[string]int{"k1": 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var polled pollResponse
+	if err := json.Unmarshal(reply.Body, &polled); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := make([]int, 0)
+	for _, pair := range polled.Messages["k1"] {
+		messages = append(messages, pair[1])
+	}
+	if !slices.IsSorted(messages) || len(slices.Compact(slices.Clone(messages))) != len(messages) {
+		t.Errorf("expected each message once, in order, got %v", messages)
+	}
+	for _, m := range acked {
+		if !slices.Contains(messages, m) {
+			t.Errorf("acknowledged message %d missing from %v", m, messages)
+		}
+	}
+
+	primary, secondary := servers[0].metrics.Snapshot(), servers[1].metrics.Snapshot()
+	if secondary.Counters["kafka.forwarded"] != 10 {
+		t.Errorf("expected 10 forwarded sends, got %d", secondary.Counters["kafka.forwarded"])
+	}
+	if secondary.Counters["retry.retries"] == 0 || primary.Counters["kafka.duplicate_sends"] == 0 {
+		t.Errorf("expected lost replies to cause retries and duplicates, got %v and %v", secondary.Counters, primary.Counters)
+	}
+}
+
+// stallingKV answers like a KV store, except that it holds the reply to the
+// first compare-and-swap for stall.
+type stallingKV struct {
+	*sim.KV
+	stall   time.Duration
+	stalled atomic.Bool
+}
+
+func (kv *stallingKV) Handle(msg maelstrom.Message) any {
+	var body maelstrom.MessageBody
+	json.Unmarshal(msg.Body, &body)
+	if body.Type == "cas" && kv.stalled.CompareAndSwap(false, true) {
+		time.Sleep(kv.stall)
+	}
+	return kv.KV.Handle(msg)
+}
+
+func TestStalledKVDoesNotWedgePrimary(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.KVTimeout = 100 * time.Millisecond
+
+	net := sim.NewNetwork(sim.Config{Latency: time.Millisecond})
+	net.AddService(maelstrom.LinKV, &stallingKV{KV: sim.NewKV(maelstrom.LinKV, sim.KVConfig{}), stall: 3 * time.Second})
+	net.AddService(maelstrom.SeqKV, sim.NewKV(maelstrom.SeqKV, sim.KVConfig{}))
+	s := NewServer(maelstrom.NewNode(), cfg)
+	net.AddNode("n0", s.n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	c := net.Client("c1")
+
+	// the store may yet apply the write, so its outcome is unknown
+	start := time.Now()
+	_, err := c.RPC(ctx, "n0", sendRequest{Type: "send", Key: "k1", Message: 10})
+	if code := maelstrom.ErrorCode(err); code != maelstrom.Crash {
+		t.Errorf("expected crash, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the send to give up after the KV timeout, took %v", elapsed)
+	}
+
+	// the lock has been released, so the next send goes through
+	if _, err := c.RPC(ctx, "n0", sendRequest{Type: "send", Key: "k1", Message: 11}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSentIDsAreForgotten(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ForwardTimeout = 50 * time.Millisecond
+
+	ctx, net, servers := newCluster(t, cfg)
+	c := net.Client("c1")
+
+	for m := 0; m < 3; m++ {
+		if _, err := c.RPC(ctx, "n1", sendRequest{Type: "send", Key: "k1", Message: m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(2*cfg.ForwardTimeout + 10*time.Millisecond)
+	if _, err := c.RPC(ctx, "n1", sendRequest{Type: "send", Key: "k1", Message: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	primary := servers[0]
+	primary.logMu.RLock()
+	defer primary.logMu.RUnlock()
+	if len(primary.sent) != 1 || len(primary.sentOrder) != 1 {
+		t.Errorf("expected only the latest send to be remembered, got %v", primary.sent)
+	}
+}
@@ -0,0 +1,252 @@
+// Package metrics counts what a node does, so that it can be asked over the
+// maelstrom protocol rather than inferred from results.edn.
+//
+// Every node has one Registry, returned by For. Metrics are created on first
+// use and named by the package which records them, e.g. "retry.attempts".
+package metrics
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/rpc"
+)
+
+// Registry holds the metrics of a single node.
+type Registry struct {
+	mu         sync.Mutex
+	counters   map[string]*Counter
+	gauges     map[string]*Gauge
+	histograms map[string]*Histogram
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:   make(map[string]*Counter),
+		gauges:     make(map[string]*Gauge),
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+var (
+	registries   = make(map[*maelstrom.Node]*Registry)
+	registriesMu sync.Mutex
+)
+
+// For returns the Registry of n, creating it if needed.
+func For(n *maelstrom.Node) *Registry {
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+
+	r, ok := registries[n]
+	if !ok {
+		r = NewRegistry()
+		registries[n] = r
+	}
+	return r
+}
+
+// Handle registers the "metrics" RPC on n, which replies with a snapshot of
+// the node's Registry.
+func Handle(n *maelstrom.Node) {
+	r := For(n)
+	rpc.Handle(n, "metrics", func(ctx context.Context, req metricsRequest) (Snapshot, error) {
+		return r.Snapshot(), nil
+	})
+}
+
+type metricsRequest struct {
+	Type string `json:"type"`
+}
+
+// Counter returns the counter with the given name.
+func (r *Registry) Counter(name string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.counters[name]
+	if !ok {
+		c = &Counter{}
+		r.counters[name] = c
+	}
+	return c
+}
+
+// Gauge returns the gauge with the given name.
+func (r *Registry) Gauge(name string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.gauges[name]
+	if !ok {
+		g = &Gauge{}
+		r.gauges[name] = g
+	}
+	return g
+}
+
+// Histogram returns the latency histogram with the given name.
+func (r *Registry) Histogram(name string) *Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h, ok := r.histograms[name]
+	if !ok {
+		h = &Histogram{counts: make([]int64, len(bounds)+1)}
+		r.histograms[name] = h
+	}
+	return h
+}
+
+// Snapshot is the value of every metric in a Registry at one point in time.
+type Snapshot struct {
+	Counters   map[string]int64             `json:"counters"`
+	Gauges     map[string]int64             `json:"gauges"`
+	Histograms map[string]HistogramSnapshot `json:"histograms"`
+}
+
+// Snapshot returns the current value of every metric.
+func (r *Registry) Snapshot() Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := Snapshot{
+		Counters:   make(map[string]int64, len(r.counters)),
+		Gauges:     make(map[string]int64, len(r.gauges)),
+		Histograms: make(map[string]HistogramSnapshot, len(r.histograms)),
+	}
+	for name, c := range r.counters {
+		s.Counters[name] = c.Value()
+	}
+	for name, g := range r.gauges {
+		s.Gauges[name] = g.Value()
+	}
+	for name, h := range r.histograms {
+		s.Histograms[name] = h.Snapshot()
+	}
+	return s
+}
+
+// Counter is a count which only goes up.
+type Counter struct {
+	v atomic.Int64
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add adds delta to the counter.
+func (c *Counter) Add(delta int64) {
+	c.v.Add(delta)
+}
+
+// Value returns the current count.
+func (c *Counter) Value() int64 {
+	return c.v.Load()
+}
+
+// Gauge is a value which can go up and down.
+type Gauge struct {
+	v atomic.Int64
+}
+
+// Set replaces the value of the gauge.
+func (g *Gauge) Set(v int64) {
+	g.v.Store(v)
+}
+
+// Add adds delta, which may be negative, to the gauge.
+func (g *Gauge) Add(delta int64) {
+	g.v.Add(delta)
+}
+
+// Value returns the current value.
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
+
+// bounds are the upper bounds of the histogram buckets, in milliseconds.
+// Anything slower falls into a final, unbounded bucket.
+var bounds = []float64{0.5, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}
+
+// Histogram records the distribution of latencies.
+type Histogram struct {
+	mu     sync.Mutex
+	counts []int64
+	count  int64
+	sum    float64
+	max    float64
+}
+
+// Observe records one latency.
+func (h *Histogram) Observe(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	i := sort.SearchFloat64s(bounds, ms)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.counts[i]++
+	h.count++
+	h.sum += ms
+	h.max = math.Max(h.max, ms)
+}
+
+// Since records the time elapsed since start.
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start))
+}
+
+// HistogramSnapshot summarises a Histogram. Quantiles are the upper bound of
+// the bucket they fall in, so they overestimate by up to one bucket.
+type HistogramSnapshot struct {
+	Count int64   `json:"count"`
+	SumMS float64 `json:"sum_ms"`
+	MaxMS float64 `json:"max_ms"`
+	P50MS float64 `json:"p50_ms"`
+	P95MS float64 `json:"p95_ms"`
+	P99MS float64 `json:"p99_ms"`
+}
+
+// Snapshot returns a summary of the latencies recorded so far.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return HistogramSnapshot{
+		Count: h.count,
+		SumMS: h.sum,
+		MaxMS: h.max,
+		P50MS: h.quantile(0.5),
+		P95MS: h.quantile(0.95),
+		P99MS: h.quantile(0.99),
+	}
+}
+
+func (h *Histogram) quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(q * float64(h.count)))
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			if i < len(bounds) {
+				return math.Min(bounds[i], h.max)
+			}
+			break
+		}
+	}
+	return h.max
+}
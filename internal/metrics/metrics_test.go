@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	r.Counter("sent").Inc()
+	r.Counter("sent").Add(2)
+	r.Gauge("pending").Set(5)
+	r.Gauge("pending").Add(-2)
+
+	s := r.Snapshot()
+	if s.Counters["sent"] != 3 {
+		t.Errorf("expected counter 3, got %d", s.Counters["sent"])
+	}
+	if s.Gauges["pending"] != 3 {
+		t.Errorf("expected gauge 3, got %d", s.Gauges["pending"])
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewRegistry().Histogram("latency")
+
+	if s := h.Snapshot(); s != (HistogramSnapshot{}) {
+		t.Errorf("expected an empty snapshot, got %+v", s)
+	}
+
+	// 90 fast observations and 10 slow ones.
+	for i := 0; i < 90; i++ {
+		h.Observe(3 * time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		h.Observe(300 * time.Millisecond)
+	}
+
+	s := h.Snapshot()
+	expected := HistogramSnapshot{Count: 100, SumMS: 3270, MaxMS: 300, P50MS: 5, P95MS: 300, P99MS: 300}
+	if s != expected {
+		t.Errorf("expected %+v, got %+v", expected, s)
+	}
+
+	// Anything past the last bucket is reported as the maximum.
+	slow := NewRegistry().Histogram("slow")
+	slow.Observe(time.Minute)
+	if s := slow.Snapshot(); s.MaxMS != 60000 || s.P50MS != 60000 {
+		t.Errorf("expected the slowest latency to be 60000ms, got %+v", s)
+	}
+}
+
+func TestMetricsRPC(t *testing.T) {
+	n := maelstrom.NewNode()
+	Handle(n)
+	For(n).Counter("requests").Inc()
+	For(n).Histogram("latency").Observe(time.Millisecond)
+
+	net := sim.NewNetwork(sim.Config{})
+	net.AddNode("n0", n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	reply, err := net.Client("c1").RPC(ctx, "n0", map[string]any{"type": "metrics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct {
+		Type string `json:"type"`
+		Snapshot
+	}
+	if err := json.Unmarshal(reply.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Type != "metrics_ok" {
+		t.Errorf("expected metrics_ok, got %q", body.Type)
+	}
+	if body.Counters["requests"] != 1 {
+		t.Errorf("expected 1 request, got %v", body.Counters)
+	}
+	if body.Histograms["latency"].Count != 1 {
+		t.Errorf("expected 1 latency, got %v", body.Histograms)
+	}
+}
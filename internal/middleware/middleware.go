@@ -0,0 +1,157 @@
+// Package middleware wraps the handlers of a maelstrom node with shared
+// behaviour, such as logging and panic recovery.
+//
+// Middleware is installed on a node with Use, before its handlers are
+// registered:
+//
+//	n := maelstrom.NewNode()
+//	middleware.Use(n, middleware.Defaults()...)
+//	echo.NewServer(n)
+//
+// Handlers registered with rpc.Handle are then wrapped by every middleware
+// installed on their node.
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Middleware wraps a handler, returning a handler which does something before
+// or after calling next.
+type Middleware func(next maelstrom.HandlerFunc) maelstrom.HandlerFunc
+
+// Chain composes middleware into one. The first middleware is the outermost,
+// so it sees each message first and each result last.
+func Chain(mws ...Middleware) Middleware {
+	return func(next maelstrom.HandlerFunc) maelstrom.HandlerFunc {
+		for i := len(mws) - 1; i >= 0; i-- {
+			next = mws[i](next)
+		}
+		return next
+	}
+}
+
+var (
+	installed   = make(map[*maelstrom.Node]Middleware)
+	installedMu sync.Mutex
+)
+
+// Use installs mws on n, after any middleware already installed. It only
+// affects handlers registered afterwards.
+func Use(n *maelstrom.Node, mws ...Middleware) {
+	installedMu.Lock()
+	defer installedMu.Unlock()
+
+	if mw, ok := installed[n]; ok {
+		mws = append([]Middleware{mw}, mws...)
+	}
+	installed[n] = Chain(mws...)
+}
+
+// Wrap returns h wrapped by the middleware installed on n.
+//
+// A Replied error returned through the middleware is dropped, since the reply
+// has already been sent.
+func Wrap(n *maelstrom.Node, h maelstrom.HandlerFunc) maelstrom.HandlerFunc {
+	installedMu.Lock()
+	mw, ok := installed[n]
+	installedMu.Unlock()
+
+	if ok {
+		h = mw(h)
+	}
+	return func(msg maelstrom.Message) error {
+		err := h(msg)
+		if errors.As(err, new(*Replied)) {
+			return nil
+		}
+		return err
+	}
+}
+
+// Replied is returned by a handler which has already sent an error reply, so
+// that middleware can see that the request failed. Returning the error itself
+// would make maelstrom reply a second time.
+type Replied struct {
+	Err *maelstrom.RPCError
+}
+
+func (r *Replied) Error() string { return r.Err.Error() }
+
+func (r *Replied) Unwrap() error { return r.Err }
+
+// Defaults returns the middleware used by every challenge node: JSON logs on
+// STDERR, and panics turned into crash replies.
+func Defaults() []Middleware {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	return []Middleware{
+		Log(logger),
+		Recover(logger),
+	}
+}
+
+// Log logs the type, source, msg_id and latency of every message handled.
+// Messages whose handler fails, including those answered with an error reply,
+// are logged as errors along with the error code.
+func Log(logger *slog.Logger) Middleware {
+	return func(next maelstrom.HandlerFunc) maelstrom.HandlerFunc {
+		return func(msg maelstrom.Message) error {
+			start := time.Now()
+			err := next(msg)
+			latency := time.Since(start)
+
+			attrs := append(messageAttrs(msg), slog.Float64("latency_ms", float64(latency.Microseconds())/1000))
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+				if rpcErr := (*maelstrom.RPCError)(nil); errors.As(err, &rpcErr) {
+					attrs = append(attrs, slog.Int("code", rpcErr.Code))
+				}
+				logger.Error("handler failed", attrs...)
+			} else {
+				logger.Info("handled", attrs...)
+			}
+
+			return err
+		}
+	}
+}
+
+// Recover stops a panicking handler from taking down the node. The panic is
+// logged with its stack, and returned as an error, which maelstrom sends back
+// as a crash.
+func Recover(logger *slog.Logger) Middleware {
+	return func(next maelstrom.HandlerFunc) maelstrom.HandlerFunc {
+		return func(msg maelstrom.Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					attrs := append(messageAttrs(msg), slog.Any("panic", r), slog.String("stack", string(debug.Stack())))
+					logger.Error("handler panicked", attrs...)
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+
+			return next(msg)
+		}
+	}
+}
+
+// messageAttrs returns the attributes which identify msg in a log entry.
+func messageAttrs(msg maelstrom.Message) []any {
+	var body maelstrom.MessageBody
+	json.Unmarshal(msg.Body, &body)
+
+	return []any{
+		slog.String("type", body.Type),
+		slog.String("src", msg.Src),
+		slog.Int("msg_id", body.MsgID),
+	}
+}
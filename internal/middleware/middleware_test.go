@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestChainOrder(t *testing.T) {
+	calls := make([]string, 0)
+	record := func(name string) Middleware {
+		return func(next maelstrom.HandlerFunc) maelstrom.HandlerFunc {
+			return func(msg maelstrom.Message) error {
+				calls = append(calls, name+" before")
+				err := next(msg)
+				calls = append(calls, name+" after")
+				return err
+			}
+		}
+	}
+
+	h := Chain(record("a"), record("b"))(func(msg maelstrom.Message) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+	h(maelstrom.Message{Body: json.RawMessage(`{}`)})
+
+	expected := []string{"a before", "b before", "handler", "b after", "a after"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	h := Log(logger)(func(msg maelstrom.Message) error {
+		time.Sleep(2 * time.Millisecond)
+		return nil
+	})
+	h(maelstrom.Message{Src: "c1", Dest: "n0", Body: json.RawMessage(`{"type":"read","msg_id":7}`)})
+
+	var entry struct {
+		Level     string  `json:"level"`
+		Type      string  `json:"type"`
+		Src       string  `json:"src"`
+		MsgID     int     `json:"msg_id"`
+		LatencyMS float64 `json:"latency_ms"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON log line, got %q: %v", buf.String(), err)
+	}
+
+	if entry.Level != "INFO" || entry.Type != "read" || entry.Src != "c1" || entry.MsgID != 7 {
+		t.Errorf("unexpected log entry %+v", entry)
+	}
+	if entry.LatencyMS < 2 {
+		t.Errorf("expected latency of at least 2ms, got %v", entry.LatencyMS)
+	}
+}
+
+func TestRecoverRepliesWithCrash(t *testing.T) {
+	n := maelstrom.NewNode()
+	var buf bytes.Buffer
+	Use(n, Recover(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+	n.Handle("boom", Wrap(n, func(msg maelstrom.Message) error {
+		panic("boom")
+	}))
+	n.Handle("ping", Wrap(n, func(msg maelstrom.Message) error {
+		return n.Reply(msg, map[string]any{"type": "ping_ok"})
+	}))
+
+	net := sim.NewNetwork(sim.Config{})
+	net.AddNode("n0", n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	c := net.Client("c1")
+
+	_, err := c.RPC(ctx, "n0", map[string]any{"type": "boom"})
+	if code := maelstrom.ErrorCode(err); code != maelstrom.Crash {
+		t.Fatalf("expected crash, got %v", err)
+	}
+
+	// The node is still running.
+	if _, err := c.RPC(ctx, "n0", map[string]any{"type": "ping"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry struct {
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+		Type  string `json:"type"`
+		Panic string `json:"panic"`
+		Stack string `json:"stack"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON log line, got %q: %v", buf.String(), err)
+	}
+	if entry.Level != "ERROR" || entry.Type != "boom" || entry.Panic != "boom" || entry.Stack == "" {
+		t.Errorf("unexpected log entry %+v", entry)
+	}
+}
+
+func TestUseAppends(t *testing.T) {
+	n := maelstrom.NewNode()
+
+	calls := make([]string, 0)
+	record := func(name string) Middleware {
+		return func(next maelstrom.HandlerFunc) maelstrom.HandlerFunc {
+			return func(msg maelstrom.Message) error {
+				calls = append(calls, name)
+				return next(msg)
+			}
+		}
+	}
+
+	Use(n, record("a"))
+	Use(n, record("b"))
+	Wrap(n, func(msg maelstrom.Message) error { return nil })(maelstrom.Message{})
+
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
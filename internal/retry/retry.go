@@ -0,0 +1,229 @@
+// Package retry provides reliable delivery of RPCs between maelstrom nodes.
+//
+// Messages are resent with exponential backoff and jitter until the
+// destination replies, the context is cancelled, or the reply carries an error
+// that retrying will not fix.
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/metrics"
+)
+
+// Config controls how a Sender retries messages.
+type Config struct {
+	// Timeout is how long a single attempt waits for a reply before it is
+	// considered lost.
+	Timeout time.Duration
+
+	// InitialBackoff is the delay after the first failed attempt.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between attempts.
+	MaxBackoff time.Duration
+
+	// Multiplier grows the delay after every failed attempt.
+	Multiplier float64
+
+	// Jitter randomises each delay by up to this fraction in either direction,
+	// so that nodes cut off by the same partition do not retry in lockstep.
+	Jitter float64
+
+	// MaxInFlight limits how many messages may be outstanding to a single
+	// destination. Zero means no limit.
+	MaxInFlight int
+}
+
+// DefaultConfig returns the configuration used by the challenge nodes.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:        time.Second,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		MaxInFlight:    32,
+	}
+}
+
+// DoneFunc is called exactly once when a message has been delivered, with the
+// reply, or when delivery is abandoned, with the error that stopped it.
+type DoneFunc func(reply maelstrom.Message, err error)
+
+// Sender delivers messages from a node, retrying until they are acknowledged.
+type Sender struct {
+	n       *maelstrom.Node
+	cfg     Config
+	metrics *metrics.Registry
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+	rand  *rand.Rand
+}
+
+// NewSender returns a Sender for messages originating from n.
+func NewSender(n *maelstrom.Node, cfg Config) *Sender {
+	return &Sender{
+		n:       n,
+		cfg:     cfg,
+		metrics: metrics.For(n),
+		slots:   make(map[string]chan struct{}),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Send delivers body to dst in a background goroutine. done may be nil.
+func (s *Sender) Send(ctx context.Context, dst string, body any, done DoneFunc) {
+	go func() {
+		reply, err := s.SendSync(ctx, dst, body)
+		if done != nil {
+			done(reply, err)
+		}
+	}()
+}
+
+// SendSync delivers body to dst, blocking until it is acknowledged or ctx is
+// done.
+//
+// Replies with a Timeout or TemporarilyUnavailable error code are retried.
+// Any other error reply ends delivery and is returned as an *RPCError, since
+// the destination has definitely seen the message.
+func (s *Sender) SendSync(ctx context.Context, dst string, body any) (maelstrom.Message, error) {
+	if err := s.acquire(ctx, dst); err != nil {
+		s.metrics.Counter("retry.abandoned").Inc()
+		return maelstrom.Message{}, err
+	}
+	defer s.release(dst)
+
+	inFlight := s.metrics.Gauge("retry.in_flight")
+	inFlight.Add(1)
+	defer inFlight.Add(-1)
+
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		s.metrics.Counter("retry.attempts").Inc()
+		if attempt > 0 {
+			s.metrics.Counter("retry.retries").Inc()
+		}
+
+		reply, err := s.attempt(ctx, dst, body)
+		if err == nil {
+			s.metrics.Histogram("retry.delivery_latency").Since(start)
+			return reply, nil
+		}
+		if !retryable(err) {
+			if ctx.Err() != nil {
+				s.metrics.Counter("retry.abandoned").Inc()
+			} else {
+				s.metrics.Counter("retry.rejected").Inc()
+			}
+			return reply, err
+		}
+
+		select {
+		case <-ctx.Done():
+			s.metrics.Counter("retry.abandoned").Inc()
+			return maelstrom.Message{}, ctx.Err()
+		case <-time.After(s.backoff(attempt)):
+		}
+	}
+}
+
+// attempt sends body once and waits up to the configured timeout for a reply.
+func (s *Sender) attempt(ctx context.Context, dst string, body any) (maelstrom.Message, error) {
+	// The channel is buffered so that a reply arriving after we have given up
+	// does not block the node's callback goroutine forever.
+	replies := make(chan maelstrom.Message, 1)
+	err := s.n.RPC(dst, body, func(msg maelstrom.Message) error {
+		select {
+		case replies <- msg:
+		default:
+		}
+		return nil
+	})
+	if err != nil {
+		return maelstrom.Message{}, err
+	}
+
+	timer := time.NewTimer(s.cfg.Timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return maelstrom.Message{}, ctx.Err()
+	case <-timer.C:
+		return maelstrom.Message{}, maelstrom.NewRPCError(maelstrom.Timeout, "no reply from "+dst)
+	case msg := <-replies:
+		if err := msg.RPCError(); err != nil {
+			return msg, err
+		}
+		return msg, nil
+	}
+}
+
+// backoff returns the delay to wait after the given failed attempt.
+func (s *Sender) backoff(attempt int) time.Duration {
+	d := float64(s.cfg.InitialBackoff)
+	limit := float64(s.cfg.MaxBackoff)
+	for i := 0; i < attempt && (limit <= 0 || d < limit); i++ {
+		d *= s.cfg.Multiplier
+	}
+	if limit > 0 && d > limit {
+		d = limit
+	}
+
+	s.mu.Lock()
+	d += d * s.cfg.Jitter * (2*s.rand.Float64() - 1)
+	s.mu.Unlock()
+
+	return time.Duration(d)
+}
+
+// acquire waits for a free in-flight slot for dst.
+func (s *Sender) acquire(ctx context.Context, dst string) error {
+	if s.cfg.MaxInFlight <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	slot, ok := s.slots[dst]
+	if !ok {
+		slot = make(chan struct{}, s.cfg.MaxInFlight)
+		s.slots[dst] = slot
+	}
+	s.mu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Sender) release(dst string) {
+	if s.cfg.MaxInFlight <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	slot := s.slots[dst]
+	s.mu.Unlock()
+
+	<-slot
+}
+
+func retryable(err error) bool {
+	switch maelstrom.ErrorCode(err) {
+	case maelstrom.Timeout, maelstrom.TemporarilyUnavailable:
+		return true
+	default:
+		return false
+	}
+}
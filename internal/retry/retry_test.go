@@ -0,0 +1,214 @@
+package retry
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// peer is a fake destination node. It reads the messages written by a node
+// and decides whether to answer each one.
+type peer struct {
+	mu       sync.Mutex
+	received int
+	reply    func(attempt int) map[string]any
+}
+
+func (p *peer) attempts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.received
+}
+
+// newNode returns a running node whose outgoing messages are answered by p.
+func newNode(t *testing.T, p *peer) *maelstrom.Node {
+	t.Helper()
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	n := maelstrom.NewNode()
+	n.Init("n0", []string{"n0", "n1"})
+	n.Stdin = inR
+	n.Stdout = outW
+
+	go n.Run()
+	go func() {
+		scanner := bufio.NewScanner(outR)
+		for scanner.Scan() {
+			var msg maelstrom.Message
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				continue
+			}
+			var body maelstrom.MessageBody
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				continue
+			}
+
+			p.mu.Lock()
+			attempt := p.received
+			p.received++
+			p.mu.Unlock()
+
+			reply := p.reply(attempt)
+			if reply == nil {
+				continue
+			}
+			reply["in_reply_to"] = body.MsgID
+			buf, _ := json.Marshal(maelstrom.Message{Src: msg.Dest, Dest: msg.Src, Body: mustMarshal(reply)})
+			inW.Write(append(buf, '\n'))
+		}
+	}()
+
+	t.Cleanup(func() {
+		inW.Close()
+		outW.Close()
+	})
+
+	return n
+}
+
+func mustMarshal(v any) json.RawMessage {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+func testConfig() Config {
+	return Config{
+		Timeout:        20 * time.Millisecond,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		Multiplier:     2,
+		Jitter:         0.2,
+		MaxInFlight:    4,
+	}
+}
+
+func TestSendRetriesUntilAcknowledged(t *testing.T) {
+	p := &peer{reply: func(attempt int) map[string]any {
+		if attempt < 3 {
+			return nil
+		}
+		return map[string]any{"type": "ping_ok"}
+	}}
+	s := NewSender(newNode(t, p), testConfig())
+
+	done := make(chan error, 1)
+	s.Send(context.Background(), "n1", map[string]any{"type": "ping"}, func(reply maelstrom.Message, err error) {
+		if err == nil && reply.Type() != "ping_ok" {
+			err = errors.New("unexpected reply type " + reply.Type())
+		}
+		done <- err
+	})
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected delivery, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was never acknowledged")
+	}
+
+	if got := p.attempts(); got != 4 {
+		t.Fatalf("expected 4 attempts, got %d", got)
+	}
+}
+
+func TestSendRetriesTemporarilyUnavailable(t *testing.T) {
+	p := &peer{reply: func(attempt int) map[string]any {
+		if attempt == 0 {
+			return map[string]any{"type": "error", "code": maelstrom.TemporarilyUnavailable}
+		}
+		return map[string]any{"type": "ping_ok"}
+	}}
+	s := NewSender(newNode(t, p), testConfig())
+
+	if _, err := s.SendSync(context.Background(), "n1", map[string]any{"type": "ping"}); err != nil {
+		t.Fatalf("expected delivery, got %v", err)
+	}
+}
+
+func TestSendStopsOnDefiniteError(t *testing.T) {
+	p := &peer{reply: func(attempt int) map[string]any {
+		return map[string]any{"type": "error", "code": maelstrom.PreconditionFailed}
+	}}
+	s := NewSender(newNode(t, p), testConfig())
+
+	_, err := s.SendSync(context.Background(), "n1", map[string]any{"type": "ping"})
+	if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Fatalf("expected precondition failed, got %v", err)
+	}
+	if got := p.attempts(); got != 1 {
+		t.Fatalf("expected 1 attempt, got %d", got)
+	}
+}
+
+func TestSendCancelled(t *testing.T) {
+	p := &peer{reply: func(attempt int) map[string]any { return nil }}
+	s := NewSender(newNode(t, p), testConfig())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := s.SendSync(ctx, "n1", map[string]any{"type": "ping"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestSendLimitsInFlight(t *testing.T) {
+	p := &peer{reply: func(attempt int) map[string]any { return nil }}
+	cfg := testConfig()
+	cfg.MaxInFlight = 1
+	s := NewSender(newNode(t, p), cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.Send(ctx, "n1", map[string]any{"type": "ping"}, nil)
+	time.Sleep(10 * time.Millisecond)
+
+	// The only slot is held by the first message, so the second must give up
+	// while still waiting for it.
+	blocked, stop := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer stop()
+
+	_, err := s.SendSync(blocked, "n1", map[string]any{"type": "ping"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected second send to be blocked, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	s := NewSender(maelstrom.NewNode(), Config{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	})
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+
+	for attempt, want := range expected {
+		if got := s.backoff(attempt); got != want {
+			t.Errorf("attempt %d: expected %v, got %v", attempt, want, got)
+		}
+	}
+}
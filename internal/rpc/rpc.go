@@ -0,0 +1,141 @@
+// Package rpc registers typed handlers on a maelstrom node.
+//
+// A handler takes a decoded request and returns a response, rather than
+// unmarshalling and replying itself:
+//
+//	rpc.Handle(n, "read", func(ctx context.Context, req readRequest) (readResponse, error) {
+//		return readResponse{Value: v}, nil
+//	})
+//
+// The reply is given the type "read_ok" unless the response sets its own, and
+// errors are sent back as maelstrom error bodies.
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/middleware"
+)
+
+// HandlerFunc handles a request of type Req and returns the body of the reply.
+type HandlerFunc[Req, Resp any] func(ctx context.Context, req Req) (Resp, error)
+
+// Handle registers h for messages of type typ on n, wrapped by any middleware
+// installed on n.
+//
+// If the body cannot be decoded into Req, a malformed-request error is sent
+// back without calling h. If h returns an error, it is sent back instead of
+// the response, see Error, and middleware sees it as a *middleware.Replied.
+func Handle[Req, Resp any](n *maelstrom.Node, typ string, h HandlerFunc[Req, Resp]) {
+	n.Handle(typ, middleware.Wrap(n, func(msg maelstrom.Message) error {
+		var req Req
+		if err := json.Unmarshal(msg.Body, &req); err != nil {
+			return replyError(n, msg, Errorf(maelstrom.MalformedRequest, "decode %s: %v", typ, err))
+		}
+
+		ctx := context.WithValue(context.Background(), messageKey{}, msg)
+		resp, err := h(ctx, req)
+		if err != nil {
+			return replyError(n, msg, err)
+		}
+
+		body, err := withType(resp, typ+"_ok")
+		if err != nil {
+			return replyError(n, msg, err)
+		}
+		return reply(n, msg, body)
+	}))
+}
+
+type messageKey struct{}
+
+// Message returns the message being handled. It is only available inside a
+// handler registered with Handle.
+func Message(ctx context.Context) maelstrom.Message {
+	msg, _ := ctx.Value(messageKey{}).(maelstrom.Message)
+	return msg
+}
+
+// Errorf returns an error which is sent back with the given code.
+func Errorf(code int, format string, args ...any) error {
+	return maelstrom.NewRPCError(code, fmt.Sprintf(format, args...))
+}
+
+// Error converts err into the error body sent back to the client.
+//
+// A *maelstrom.RPCError anywhere in the chain keeps its code, so errors from
+// the KV stores and from other nodes are passed on unchanged. A context which
+// ran out of time becomes a timeout, and anything else is a crash.
+func Error(err error) *maelstrom.RPCError {
+	var rpcErr *maelstrom.RPCError
+	switch {
+	case errors.As(err, &rpcErr):
+		return rpcErr
+	case errors.Is(err, context.DeadlineExceeded):
+		return maelstrom.NewRPCError(maelstrom.Timeout, err.Error())
+	default:
+		return maelstrom.NewRPCError(maelstrom.Crash, err.Error())
+	}
+}
+
+// withType encodes resp as a JSON object, setting its type to typ if it does
+// not have one.
+//
+// Fields are kept as raw JSON, rather than decoded into map[string]any as
+// maelstrom.Node.Reply does, so large integers do not lose precision.
+func withType(resp any, typ string) (map[string]json.RawMessage, error) {
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(buf, &body); err != nil {
+		return nil, fmt.Errorf("response to %s is not an object: %w", typ, err)
+	}
+	if body == nil {
+		body = make(map[string]json.RawMessage)
+	}
+
+	if t := body["type"]; len(t) == 0 || string(t) == `""` {
+		body["type"], _ = json.Marshal(typ)
+	}
+	return body, nil
+}
+
+// replyError sends err back to the source of msg as an error body, see Error.
+// It returns the error as a *middleware.Replied, so that middleware sees the
+// request failed without maelstrom replying again.
+func replyError(n *maelstrom.Node, msg maelstrom.Message, err error) error {
+	rpcErr := Error(err)
+	if err := reply(n, msg, rpcErr); err != nil {
+		return err
+	}
+	return &middleware.Replied{Err: rpcErr}
+}
+
+// reply sends body back to the source of msg, in reply to its msg_id.
+func reply(n *maelstrom.Node, msg maelstrom.Message, body any) error {
+	var reqBody maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &reqBody); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	b := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(buf, &b); err != nil {
+		return err
+	}
+	b["in_reply_to"], _ = json.Marshal(reqBody.MsgID)
+
+	return n.Send(msg.Src, b)
+}
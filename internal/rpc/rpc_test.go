@@ -0,0 +1,207 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/middleware"
+	"fly-io-dist-sys/internal/sim"
+)
+
+type addRequest struct {
+	Type string `json:"type"`
+	A    int64  `json:"a"`
+	B    int64  `json:"b"`
+}
+
+type addResponse struct {
+	Sum  int64  `json:"sum"`
+	From string `json:"from"`
+}
+
+func newClient(t *testing.T) (context.Context, *sim.Client) {
+	t.Helper()
+
+	n := maelstrom.NewNode()
+	Handle(n, "add", func(ctx context.Context, req addRequest) (addResponse, error) {
+		switch {
+		case req.A < 0:
+			return addResponse{}, Errorf(maelstrom.PreconditionFailed, "a is negative")
+		case req.B < 0:
+			return addResponse{}, fmt.Errorf("add: %w", errors.New("b is negative"))
+		}
+		return addResponse{Sum: req.A + req.B, From: Message(ctx).Src}, nil
+	})
+	Handle(n, "named", func(ctx context.Context, req addRequest) (map[string]any, error) {
+		return map[string]any{"type": "custom"}, nil
+	})
+
+	net := sim.NewNetwork(sim.Config{})
+	net.AddNode("n0", n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(net.Close)
+
+	return ctx, net.Client("c1")
+}
+
+func TestHandle(t *testing.T) {
+	ctx, c := newClient(t)
+
+	// Large integers must survive the round trip without going through float64.
+	reply, err := c.RPC(ctx, "n0", addRequest{Type: "add", A: 1 << 60, B: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct {
+		maelstrom.MessageBody
+		addResponse
+	}
+	if err := json.Unmarshal(reply.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Type != "add_ok" {
+		t.Errorf("expected add_ok, got %q", body.Type)
+	}
+	if body.InReplyTo == 0 {
+		t.Error("expected in_reply_to to be set")
+	}
+	if body.Sum != 1<<60+1 {
+		t.Errorf("expected %d, got %d", int64(1<<60+1), body.Sum)
+	}
+	if body.From != "c1" {
+		t.Errorf("expected c1, got %q", body.From)
+	}
+
+	reply, err = c.RPC(ctx, "n0", addRequest{Type: "named"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(reply.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Type != "custom" {
+		t.Errorf("expected custom, got %q", body.Type)
+	}
+}
+
+func TestHandleErrors(t *testing.T) {
+	ctx, c := newClient(t)
+
+	tests := []struct {
+		name string
+		body any
+		code int
+	}{
+		{"rpc error", addRequest{Type: "add", A: -1}, maelstrom.PreconditionFailed},
+		{"plain error", addRequest{Type: "add", B: -1}, maelstrom.Crash},
+		{"malformed", map[string]any{"type": "add", "a": "one"}, maelstrom.MalformedRequest},
+	}
+
+	for _, tt := range tests {
+		_, err := c.RPC(ctx, "n0", tt.body)
+		if code := maelstrom.ErrorCode(err); code != tt.code {
+			t.Errorf("%s: expected code %d, got %d (%v)", tt.name, tt.code, code, err)
+		}
+	}
+}
+
+// logBuffer collects log lines written by the node's goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return strings.Split(strings.TrimSpace(b.buf.String()), "\n")
+}
+
+func TestHandleLogsErrorReplies(t *testing.T) {
+	logs := &logBuffer{}
+	n := maelstrom.NewNode()
+	middleware.Use(n, middleware.Log(slog.New(slog.NewJSONHandler(logs, nil))))
+	Handle(n, "read", func(ctx context.Context, req struct{}) (struct{}, error) {
+		return struct{}{}, Errorf(maelstrom.KeyDoesNotExist, "no such key")
+	})
+
+	net := sim.NewNetwork(sim.Config{})
+	net.AddNode("n0", n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	_, err := net.Client("c1").RPC(ctx, "n0", map[string]any{"type": "read"})
+	if code := maelstrom.ErrorCode(err); code != maelstrom.KeyDoesNotExist {
+		t.Fatalf("expected key-does-not-exist, got %v", err)
+	}
+
+	// the entry is written once the handler returns, after the reply is sent
+	var entry struct {
+		Level string `json:"level"`
+		Type  string `json:"type"`
+		Code  int    `json:"code"`
+	}
+	for entry.Type == "" {
+		if ctx.Err() != nil {
+			t.Fatal("expected the request to be logged")
+		}
+		json.Unmarshal([]byte(logs.lines()[0]), &entry)
+		time.Sleep(time.Millisecond)
+	}
+
+	if entry.Level != "ERROR" || entry.Type != "read" || entry.Code != maelstrom.KeyDoesNotExist {
+		t.Errorf("expected the failed read to be logged as an error, got %+v", entry)
+	}
+	if lines := logs.lines(); len(lines) != 1 {
+		t.Errorf("expected one log line, got %q", lines)
+	}
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("wrapped: %w", maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "missing")), maelstrom.KeyDoesNotExist},
+		{fmt.Errorf("read: %w", context.DeadlineExceeded), maelstrom.Timeout},
+		{errors.New("boom"), maelstrom.Crash},
+	}
+
+	for _, tt := range tests {
+		if got := Error(tt.err).Code; got != tt.code {
+			t.Errorf("%v: expected code %d, got %d", tt.err, tt.code, got)
+		}
+	}
+}
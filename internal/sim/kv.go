@@ -0,0 +1,164 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// KVConfig controls the behaviour of a simulated key/value store.
+type KVConfig struct {
+	// StaleReads is the probability that a read is served from an older
+	// version of the store than the newest one. It has no effect on lin-kv.
+	StaleReads float64
+
+	// Seed seeds the store's random number generator.
+	Seed int64
+}
+
+// KV is a Service implementing maelstrom's lin-kv, seq-kv and lww-kv
+// protocols.
+//
+// Every write creates a new version of the store, and old versions are kept
+// so that seq-kv and lww-kv can serve stale reads:
+//
+//   - lin-kv always serves the newest version.
+//   - seq-kv serves any version at least as new as the last one the client
+//     has seen, so each client observes the store moving forwards through a
+//     single order of writes.
+//   - lww-kv serves any version, so a client may see a value and then an older
+//     one.
+//
+// Writes and compare-and-swaps always apply to the newest version.
+type KV struct {
+	typ string
+	cfg KVConfig
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	version int
+	history map[string][]kvVersion
+	seen    map[string]int
+}
+
+// kvVersion is the value of a key from a given version of the store.
+type kvVersion struct {
+	version int
+	value   any
+}
+
+// NewKV returns an empty store of the given type, which is one of
+// maelstrom.LinKV, maelstrom.SeqKV or maelstrom.LWWKV.
+func NewKV(typ string, cfg KVConfig) *KV {
+	return &KV{
+		typ:     typ,
+		cfg:     cfg,
+		rand:    rand.New(rand.NewSource(cfg.Seed)),
+		history: make(map[string][]kvVersion),
+		seen:    make(map[string]int),
+	}
+}
+
+// kvRequest is the union of the read, write and cas request bodies.
+type kvRequest struct {
+	Type              string `json:"type"`
+	Key               any    `json:"key"`
+	Value             any    `json:"value"`
+	From              any    `json:"from"`
+	To                any    `json:"to"`
+	CreateIfNotExists bool   `json:"create_if_not_exists"`
+}
+
+type kvResponse struct {
+	Type string `json:"type"`
+}
+
+type kvReadResponse struct {
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+// Handle implements Service.
+func (kv *KV) Handle(msg maelstrom.Message) any {
+	var req kvRequest
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+	}
+	key := fmt.Sprint(req.Key)
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	switch req.Type {
+	case "read":
+		value, ok := kv.read(msg.Src, key)
+		if !ok {
+			return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		return kvReadResponse{Type: "read_ok", Value: value}
+
+	case "write":
+		kv.write(msg.Src, key, req.Value)
+		return kvResponse{Type: "write_ok"}
+
+	case "cas":
+		current, ok := kv.latest(key)
+		switch {
+		case !ok && !req.CreateIfNotExists:
+			return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		case ok && !reflect.DeepEqual(current, req.From):
+			return maelstrom.NewRPCError(maelstrom.PreconditionFailed,
+				fmt.Sprintf("expected %v, but had %v", req.From, current))
+		}
+		kv.write(msg.Src, key, req.To)
+		return kvResponse{Type: "cas_ok"}
+
+	default:
+		return maelstrom.NewRPCError(maelstrom.NotSupported, "unknown operation "+req.Type)
+	}
+}
+
+// read returns the value of key from a version of the store the client is
+// allowed to see.
+func (kv *KV) read(client, key string) (any, bool) {
+	version := kv.version
+	if kv.typ != maelstrom.LinKV && kv.rand.Float64() < kv.cfg.StaleReads {
+		oldest := 0
+		if kv.typ == maelstrom.SeqKV {
+			oldest = kv.seen[client]
+		}
+		version = oldest + kv.rand.Intn(kv.version-oldest+1)
+	}
+
+	if kv.typ == maelstrom.SeqKV {
+		kv.seen[client] = version
+	}
+
+	history := kv.history[key]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].version <= version {
+			return history[i].value, true
+		}
+	}
+	return nil, false
+}
+
+// latest returns the newest value of key.
+func (kv *KV) latest(key string) (any, bool) {
+	history := kv.history[key]
+	if len(history) == 0 {
+		return nil, false
+	}
+	return history[len(history)-1].value, true
+}
+
+// write creates a new version of the store in which key has the given value.
+func (kv *KV) write(client, key string, value any) {
+	kv.version++
+	kv.history[key] = append(kv.history[key], kvVersion{version: kv.version, value: value})
+	kv.seen[client] = kv.version
+}
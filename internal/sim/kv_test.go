@@ -0,0 +1,135 @@
+package sim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// startKV returns a client node connected to a network with a single store
+// of the given type.
+func startKV(t *testing.T, typ string, cfg KVConfig) *maelstrom.KV {
+	t.Helper()
+
+	net := NewNetwork(Config{})
+	net.AddService(typ, NewKV(typ, cfg))
+
+	n := maelstrom.NewNode()
+	net.AddNode("n0", n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(net.Close)
+
+	return maelstrom.NewKV(typ, n)
+}
+
+func TestLinKV(t *testing.T) {
+	kv := startKV(t, maelstrom.LinKV, KVConfig{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := kv.Read(ctx, "k"); maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		t.Fatalf("expected key does not exist, got %v", err)
+	}
+
+	if err := kv.CompareAndSwap(ctx, "k", 0, 1, false); maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		t.Fatalf("expected key does not exist, got %v", err)
+	}
+
+	if err := kv.CompareAndSwap(ctx, "k", 0, 1, true); err != nil {
+		t.Fatalf("expected cas to create key, got %v", err)
+	}
+
+	if err := kv.CompareAndSwap(ctx, "k", 0, 2, true); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Fatalf("expected precondition failed, got %v", err)
+	}
+
+	if err := kv.CompareAndSwap(ctx, "k", 1, 2, false); err != nil {
+		t.Fatalf("expected cas to succeed, got %v", err)
+	}
+
+	if err := kv.Write(ctx, "list", []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.CompareAndSwap(ctx, "list", []int{1, 2}, []int{1, 2, 3}, false); err != nil {
+		t.Fatalf("expected cas on a list to succeed, got %v", err)
+	}
+
+	if v, err := kv.ReadInt(ctx, "k"); err != nil || v != 2 {
+		t.Fatalf("expected 2, got %v (%v)", v, err)
+	}
+
+	var list []int
+	if err := kv.ReadInto(ctx, "list", &list); err != nil || len(list) != 3 {
+		t.Fatalf("expected [1 2 3], got %v (%v)", list, err)
+	}
+}
+
+func TestSeqKVStaleReadsAreMonotonic(t *testing.T) {
+	kv := startKV(t, maelstrom.SeqKV, KVConfig{StaleReads: 1, Seed: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Another process writes, so the reading node has seen none of it.
+	store := NewKV(maelstrom.SeqKV, KVConfig{StaleReads: 1, Seed: 1})
+	for i := 0; i < 10; i++ {
+		store.write("n1", "k", float64(i))
+	}
+	stale := 0
+	last := -1
+	for i := 0; i < 100; i++ {
+		v, ok := store.read("n0", "k")
+		if !ok {
+			stale++
+			continue
+		}
+		if int(v.(float64)) < last {
+			t.Fatalf("read went backwards from %d to %v", last, v)
+		}
+		if int(v.(float64)) < 9 {
+			stale++
+		}
+		last = int(v.(float64))
+	}
+	if stale == 0 {
+		t.Error("expected some stale reads")
+	}
+
+	// A client always sees its own writes.
+	for i := 0; i < 10; i++ {
+		if err := kv.Write(ctx, "k", i); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := kv.ReadInt(ctx, "k"); err != nil || v != i {
+			t.Fatalf("expected %d, got %v (%v)", i, v, err)
+		}
+	}
+}
+
+func TestLWWKVStaleReadsCanGoBackwards(t *testing.T) {
+	store := NewKV(maelstrom.LWWKV, KVConfig{StaleReads: 1, Seed: 1})
+	for i := 0; i < 10; i++ {
+		store.write("n1", "k", float64(i))
+	}
+
+	last := -1
+	for i := 0; i < 100; i++ {
+		v, ok := store.read("n0", "k")
+		if ok && int(v.(float64)) < last {
+			return
+		}
+		if ok {
+			last = int(v.(float64))
+		}
+	}
+	t.Error("expected a read to go backwards")
+}
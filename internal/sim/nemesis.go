@@ -0,0 +1,294 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Fault is a kind of failure the nemesis can inject.
+type Fault string
+
+const (
+	// PartitionMajority splits the nodes into a majority and a minority.
+	PartitionMajority Fault = "partition-majority"
+
+	// PartitionBridge splits the nodes into two halves which can only talk to
+	// each other through a single bridge node.
+	PartitionBridge Fault = "partition-bridge"
+
+	// PartitionIsolated cuts a single node off from every other node.
+	PartitionIsolated Fault = "partition-isolated"
+
+	// Pause freezes a single node. Messages to and from it are held until it
+	// is resumed.
+	Pause Fault = "pause"
+
+	// Heal removes all partitions and resumes all paused nodes.
+	Heal Fault = "heal"
+)
+
+// NemesisConfig controls which faults a nemesis injects.
+//
+// Every decision the nemesis makes is derived from Seed, so two runs with the
+// same seed see the same schedule of faults and the same messages dropped,
+// duplicated and delayed.
+type NemesisConfig struct {
+	Seed int64
+
+	// Faults are the faults the schedule chooses from. Faults are always
+	// followed by a Heal.
+	Faults []Fault
+
+	// Interval is how long each step of the schedule lasts when driven by
+	// Run.
+	Interval time.Duration
+
+	// DropRate is the probability that a message between nodes is lost.
+	DropRate float64
+
+	// DuplicateRate is the probability that a message between nodes is
+	// delivered twice.
+	DuplicateRate float64
+
+	// ReorderRate is the probability that a message between nodes is held
+	// back by up to ReorderDelay, so that later messages overtake it.
+	ReorderRate  float64
+	ReorderDelay time.Duration
+}
+
+// Step is a single entry in the nemesis' schedule.
+type Step struct {
+	Fault Fault
+
+	// Groups are the sides of a partition. Nodes in different groups cannot
+	// talk to each other, except for Bridge which can talk to everyone.
+	Groups [][]string
+	Bridge string
+
+	// Paused is the node frozen by a Pause.
+	Paused string
+}
+
+func (s Step) String() string {
+	switch s.Fault {
+	case Pause:
+		return fmt.Sprintf("%s %s", s.Fault, s.Paused)
+	case Heal:
+		return string(s.Fault)
+	}
+
+	groups := make([]string, 0, len(s.Groups))
+	for _, g := range s.Groups {
+		groups = append(groups, "["+strings.Join(g, " ")+"]")
+	}
+	if s.Bridge != "" {
+		return fmt.Sprintf("%s %s via %s", s.Fault, strings.Join(groups, " "), s.Bridge)
+	}
+	return fmt.Sprintf("%s %s", s.Fault, strings.Join(groups, " "))
+}
+
+// Nemesis injects faults into a Network.
+type Nemesis struct {
+	net *Network
+	cfg NemesisConfig
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	steps   int
+	history []Step
+	blocked map[[2]string]bool
+	paused  string
+	held    []maelstrom.Message
+}
+
+// NewNemesis attaches a nemesis to net. No faults are active until the
+// schedule is advanced with Step or Run, but message-level faults (drops,
+// duplicates and reordering) apply immediately.
+func NewNemesis(net *Network, cfg NemesisConfig) *Nemesis {
+	nem := &Nemesis{
+		net:     net,
+		cfg:     cfg,
+		rand:    rand.New(rand.NewSource(cfg.Seed)),
+		blocked: make(map[[2]string]bool),
+	}
+
+	net.mu.Lock()
+	net.nemesis = nem
+	net.mu.Unlock()
+
+	return nem
+}
+
+// Run advances the schedule every Interval until ctx is done, then heals the
+// network.
+func (nem *Nemesis) Run(ctx context.Context) {
+	ticker := time.NewTicker(nem.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			nem.Apply(Step{Fault: Heal})
+			return
+		case <-ticker.C:
+			nem.Step()
+		}
+	}
+}
+
+// Step applies the next step of the schedule and returns it.
+func (nem *Nemesis) Step() Step {
+	nem.mu.Lock()
+	step := nem.next()
+	nem.mu.Unlock()
+
+	nem.Apply(step)
+	return step
+}
+
+// History returns every step applied so far.
+func (nem *Nemesis) History() []Step {
+	nem.mu.Lock()
+	defer nem.mu.Unlock()
+
+	return append([]Step(nil), nem.history...)
+}
+
+// next generates the next step of the schedule. Faults alternate with heals.
+func (nem *Nemesis) next() Step {
+	nem.steps++
+	if nem.steps%2 == 0 || len(nem.cfg.Faults) == 0 {
+		return Step{Fault: Heal}
+	}
+
+	ids := nem.net.NodeIDs()
+	nem.rand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+
+	fault := nem.cfg.Faults[nem.rand.Intn(len(nem.cfg.Faults))]
+	switch fault {
+	case PartitionMajority:
+		majority := len(ids)/2 + 1
+		return Step{Fault: fault, Groups: [][]string{sorted(ids[:majority]), sorted(ids[majority:])}}
+	case PartitionBridge:
+		half := (len(ids) - 1) / 2
+		return Step{Fault: fault, Groups: [][]string{sorted(ids[1 : 1+half]), sorted(ids[1+half:])}, Bridge: ids[0]}
+	case PartitionIsolated:
+		return Step{Fault: fault, Groups: [][]string{{ids[0]}, sorted(ids[1:])}}
+	case Pause:
+		return Step{Fault: fault, Paused: ids[0]}
+	default:
+		return Step{Fault: Heal}
+	}
+}
+
+// Apply replaces the active faults with the given step. It can be used to
+// replay a schedule recorded with History.
+func (nem *Nemesis) Apply(step Step) {
+	nem.mu.Lock()
+	nem.history = append(nem.history, step)
+
+	nem.blocked = make(map[[2]string]bool)
+	for i, a := range step.Groups {
+		for j, b := range step.Groups {
+			if i == j {
+				continue
+			}
+			for _, src := range a {
+				for _, dest := range b {
+					nem.blocked[[2]string{src, dest}] = true
+				}
+			}
+		}
+	}
+
+	nem.paused = step.Paused
+
+	var released []maelstrom.Message
+	if nem.paused == "" {
+		released = nem.held
+		nem.held = nil
+	}
+	nem.mu.Unlock()
+
+	// Messages held while a node was paused are sent on once it resumes.
+	for _, msg := range released {
+		nem.net.send(msg)
+	}
+}
+
+// hold keeps msg back if it is to or from a paused node, returning whether it
+// did so.
+func (nem *Nemesis) hold(msg maelstrom.Message) bool {
+	nem.mu.Lock()
+	defer nem.mu.Unlock()
+
+	if nem.paused == "" || (msg.Src != nem.paused && msg.Dest != nem.paused) {
+		return false
+	}
+
+	nem.held = append(nem.held, msg)
+	return true
+}
+
+// partitioned reports whether msg crosses a partition.
+func (nem *Nemesis) partitioned(msg maelstrom.Message) bool {
+	nem.mu.Lock()
+	defer nem.mu.Unlock()
+
+	return nem.blocked[[2]string{msg.Src, msg.Dest}]
+}
+
+// delays returns the extra delay for each copy of msg that should be
+// delivered. An empty result means the message is dropped.
+//
+// Decisions are derived from the seed and the message itself, rather than
+// the shared random number generator, so they do not depend on the order in
+// which concurrent goroutines send messages.
+func (nem *Nemesis) delays(msg maelstrom.Message) []time.Duration {
+	if !nem.net.isNode(msg.Src) || !nem.net.isNode(msg.Dest) {
+		return []time.Duration{0}
+	}
+
+	r := nem.messageRand(msg)
+
+	if r.Float64() < nem.cfg.DropRate {
+		return nil
+	}
+
+	copies := 1
+	if r.Float64() < nem.cfg.DuplicateRate {
+		copies = 2
+	}
+
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		if nem.cfg.ReorderDelay > 0 && r.Float64() < nem.cfg.ReorderRate {
+			delays[i] = time.Duration(r.Int63n(int64(nem.cfg.ReorderDelay)))
+		}
+	}
+	return delays
+}
+
+func (nem *Nemesis) messageRand(msg maelstrom.Message) *rand.Rand {
+	var body maelstrom.MessageBody
+	json.Unmarshal(msg.Body, &body)
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d|%s|%s|%s|%d|%d", nem.cfg.Seed, msg.Src, msg.Dest, body.Type, body.MsgID, body.InReplyTo)
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+func sorted(ids []string) []string {
+	ids = append([]string(nil), ids...)
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,144 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func schedule(seed int64, steps int) []string {
+	net := NewNetwork(Config{})
+	for _, id := range []string{"n0", "n1", "n2", "n3", "n4"} {
+		net.AddNode(id, maelstrom.NewNode())
+	}
+
+	nem := NewNemesis(net, NemesisConfig{
+		Seed:   seed,
+		Faults: []Fault{PartitionMajority, PartitionBridge, PartitionIsolated, Pause},
+	})
+
+	var history []string
+	for i := 0; i < steps; i++ {
+		history = append(history, nem.Step().String())
+	}
+	return history
+}
+
+func TestScheduleIsDeterministic(t *testing.T) {
+	a := schedule(42, 20)
+	b := schedule(42, 20)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("same seed gave different schedules:\n%v\n%v", a, b)
+	}
+
+	if c := schedule(43, 20); reflect.DeepEqual(a, c) {
+		t.Fatalf("different seeds gave the same schedule: %v", a)
+	}
+
+	for i, step := range a {
+		if i%2 == 1 && step != string(Heal) {
+			t.Errorf("expected step %d to heal, got %s", i, step)
+		}
+	}
+}
+
+func TestMessageFaultsAreDeterministic(t *testing.T) {
+	net := NewNetwork(Config{})
+	net.AddNode("n0", maelstrom.NewNode())
+	net.AddNode("n1", maelstrom.NewNode())
+
+	cfg := NemesisConfig{Seed: 7, DropRate: 0.3, DuplicateRate: 0.3, ReorderRate: 0.3, ReorderDelay: time.Second}
+	a := NewNemesis(net, cfg)
+	b := NewNemesis(net, cfg)
+
+	dropped := 0
+	for i := 0; i < 100; i++ {
+		msg := maelstrom.Message{Src: "n0", Dest: "n1", Body: []byte(fmt.Sprintf(`{"type":"ping","msg_id":%d}`, i))}
+		da, db := a.delays(msg), b.delays(msg)
+		if !reflect.DeepEqual(da, db) {
+			t.Fatalf("message %d: %v != %v", i, da, db)
+		}
+		if len(da) == 0 {
+			dropped++
+		}
+	}
+	if dropped == 0 || dropped == 100 {
+		t.Errorf("expected some but not all messages to be dropped, got %d", dropped)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	net := startRelays(t, Config{}, 3)
+	nem := NewNemesis(net, NemesisConfig{})
+	c := net.Client("c1")
+
+	nem.Apply(Step{Fault: PartitionIsolated, Groups: [][]string{{"n1"}, {"n0", "n2"}}})
+
+	// n0 relays to n1, which is on the other side of the partition.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.RPC(ctx, "n0", map[string]any{"type": "relay"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected relay across partition to time out, got %v", err)
+	}
+
+	// Clients can still reach every node.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := c.RPC(ctx, "n1", map[string]any{"type": "echo"}); err != nil {
+		t.Fatalf("expected client to reach isolated node, got %v", err)
+	}
+
+	nem.Apply(Step{Fault: Heal})
+	if _, err := c.RPC(ctx, "n0", map[string]any{"type": "relay"}); err != nil {
+		t.Fatalf("expected relay to succeed after heal, got %v", err)
+	}
+}
+
+func TestPause(t *testing.T) {
+	net := startRelays(t, Config{}, 2)
+	nem := NewNemesis(net, NemesisConfig{})
+	c := net.Client("c1")
+
+	nem.Apply(Step{Fault: Pause, Paused: "n1"})
+
+	replies := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := c.RPC(ctx, "n1", map[string]any{"type": "echo"})
+		replies <- err
+	}()
+
+	select {
+	case err := <-replies:
+		t.Fatalf("paused node replied: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	nem.Apply(Step{Fault: Heal})
+
+	if err := <-replies; err != nil {
+		t.Fatalf("expected reply once resumed, got %v", err)
+	}
+}
+
+func TestDropOnlyAffectsNodes(t *testing.T) {
+	net := startRelays(t, Config{}, 2)
+	NewNemesis(net, NemesisConfig{DropRate: 1})
+	c := net.Client("c1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.RPC(ctx, "n0", map[string]any{"type": "echo"}); err != nil {
+		t.Fatalf("expected client messages to be delivered, got %v", err)
+	}
+	if _, err := c.RPC(ctx, "n0", map[string]any{"type": "relay"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected messages between nodes to be dropped, got %v", err)
+	}
+}
@@ -0,0 +1,461 @@
+// Package sim runs maelstrom nodes in a single process for testing.
+//
+// Each node's STDIN and STDOUT are wired to a simulated network which delivers
+// messages between nodes with a configurable latency. Tests act as maelstrom
+// clients, sending requests to nodes and asserting on the replies.
+package sim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Config controls the behaviour of a simulated network.
+type Config struct {
+	// Latency is the minimum delay before a message is delivered.
+	Latency time.Duration
+
+	// Jitter adds a uniformly random delay of up to this duration on top of
+	// Latency.
+	Jitter time.Duration
+
+	// Seed seeds the network's random number generator.
+	Seed int64
+}
+
+// Network is a simulated maelstrom network connecting nodes and clients.
+type Network struct {
+	cfg Config
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	nodes    map[string]*endpoint
+	order    []string
+	clients  map[string]*Client
+	services map[string]Service
+	nemesis  *Nemesis
+	closed   bool
+}
+
+// endpoint is a node attached to the network.
+type endpoint struct {
+	n      *maelstrom.Node
+	stdout *lineWriter
+
+	mu    sync.Mutex
+	stdin *io.PipeWriter
+
+	// ready is set once the node has been initialised. Until then, only its
+	// init message is delivered, as a real node only starts serving requests
+	// once maelstrom has initialised it.
+	ready bool
+}
+
+// NewNetwork returns an empty network.
+func NewNetwork(cfg Config) *Network {
+	return &Network{
+		cfg:      cfg,
+		rand:     rand.New(rand.NewSource(cfg.Seed)),
+		nodes:    make(map[string]*endpoint),
+		clients:  make(map[string]*Client),
+		services: make(map[string]Service),
+	}
+}
+
+// AddNode attaches n to the network under the given ID. n's Stdin and Stdout
+// are replaced, so handlers must be registered on n but it must not be run.
+func (net *Network) AddNode(id string, n *maelstrom.Node) {
+	e := net.attach(n)
+
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	net.nodes[id] = e
+	net.order = append(net.order, id)
+}
+
+// attach wires n's Stdin and Stdout to the network.
+func (net *Network) attach(n *maelstrom.Node) *endpoint {
+	r, w := io.Pipe()
+	stdout := &lineWriter{net: net}
+	n.Stdin = r
+	n.Stdout = stdout
+
+	return &endpoint{n: n, stdout: stdout, stdin: w}
+}
+
+// AddService attaches a built-in service, such as a key/value store, under the
+// given ID.
+func (net *Network) AddService(id string, svc Service) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	net.services[id] = svc
+}
+
+// NodeIDs returns the IDs of all nodes, in the order they were added.
+func (net *Network) NodeIDs() []string {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	return append([]string(nil), net.order...)
+}
+
+// Start runs every node and sends each an init message, returning once all of
+// them have acknowledged it.
+func (net *Network) Start(ctx context.Context) error {
+	ids := net.NodeIDs()
+
+	net.mu.Lock()
+	for _, id := range ids {
+		go net.nodes[id].n.Run()
+	}
+	net.mu.Unlock()
+
+	for _, id := range ids {
+		if err := net.init(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// init sends node id its init message and waits for the acknowledgement.
+func (net *Network) init(ctx context.Context, id string) error {
+	body := maelstrom.InitMessageBody{
+		MessageBody: maelstrom.MessageBody{Type: "init"},
+		NodeID:      id,
+		NodeIDs:     net.NodeIDs(),
+	}
+	if _, err := net.Client(initClient).RPC(ctx, id, body); err != nil {
+		return fmt.Errorf("init %s: %w", id, err)
+	}
+
+	net.mu.Lock()
+	e := net.nodes[id]
+	net.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.ready = true
+	return nil
+}
+
+// initClient is the client which initialises nodes.
+const initClient = "c0"
+
+// Kill stops node id as if its process had crashed. Messages sent to it are
+// lost, and anything its in-flight handlers send is discarded.
+func (net *Network) Kill(id string) {
+	net.mu.Lock()
+	e, ok := net.nodes[id]
+	net.mu.Unlock()
+
+	if ok {
+		e.kill()
+	}
+}
+
+// Restart kills node id, if it is still running, and replaces it with n, which
+// is run and initialised under the same ID. As with AddNode, handlers must
+// already be registered on n.
+//
+// Anything the old node kept in memory is lost, so n only knows what it
+// persisted itself or can learn from the rest of the cluster.
+func (net *Network) Restart(ctx context.Context, id string, n *maelstrom.Node) error {
+	e := net.attach(n)
+
+	net.mu.Lock()
+	old, ok := net.nodes[id]
+	if !ok {
+		net.mu.Unlock()
+		return fmt.Errorf("unknown node %s", id)
+	}
+	net.nodes[id] = e
+	net.mu.Unlock()
+
+	old.kill()
+	go n.Run()
+
+	return net.init(ctx, id)
+}
+
+// Close disconnects every node. Messages still travelling through the network
+// are dropped, and each node stops once its in-flight handlers return.
+func (net *Network) Close() {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	net.closed = true
+	for _, e := range net.nodes {
+		e.stdin.Close()
+	}
+}
+
+// Client returns the client with the given ID, creating it if necessary.
+// Client IDs conventionally start with "c".
+func (net *Network) Client(id string) *Client {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	c, ok := net.clients[id]
+	if !ok {
+		c = &Client{id: id, net: net, pending: make(map[int]chan maelstrom.Message)}
+		net.clients[id] = c
+	}
+	return c
+}
+
+// send routes msg to its destination after the configured delay.
+func (net *Network) send(msg maelstrom.Message) {
+	net.mu.Lock()
+	if net.closed {
+		net.mu.Unlock()
+		return
+	}
+	delay := net.cfg.Latency
+	if net.cfg.Jitter > 0 {
+		delay += time.Duration(net.rand.Int63n(int64(net.cfg.Jitter)))
+	}
+	nem := net.nemesis
+	net.mu.Unlock()
+
+	extra := []time.Duration{0}
+	if nem != nil {
+		if nem.hold(msg) {
+			return
+		}
+		extra = nem.delays(msg)
+	}
+
+	for _, d := range extra {
+		time.AfterFunc(delay+d, func() { net.deliver(msg) })
+	}
+}
+
+// deliver hands msg to the node, client or service it is addressed to.
+func (net *Network) deliver(msg maelstrom.Message) {
+	net.mu.Lock()
+	node, isNode := net.nodes[msg.Dest]
+	client, isClient := net.clients[msg.Dest]
+	svc, isService := net.services[msg.Dest]
+	nem := net.nemesis
+	closed := net.closed
+	net.mu.Unlock()
+
+	if nem != nil && (nem.partitioned(msg) || nem.hold(msg)) {
+		return
+	}
+
+	switch {
+	case closed:
+	case isNode:
+		node.write(msg)
+	case isClient:
+		client.receive(msg)
+	case isService:
+		net.serve(svc, msg)
+	}
+}
+
+// isNode reports whether id is a node rather than a client or service.
+func (net *Network) isNode(id string) bool {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	_, ok := net.nodes[id]
+	return ok
+}
+
+// serve passes msg to a service and sends its reply back to the sender.
+func (net *Network) serve(svc Service, msg maelstrom.Message) {
+	var req maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return
+	}
+
+	b := make(map[string]any)
+	if buf, err := json.Marshal(svc.Handle(msg)); err != nil {
+		return
+	} else if err := json.Unmarshal(buf, &b); err != nil {
+		return
+	}
+	b["in_reply_to"] = req.MsgID
+
+	buf, err := json.Marshal(b)
+	if err != nil {
+		return
+	}
+
+	net.send(maelstrom.Message{Src: msg.Dest, Dest: msg.Src, Body: buf})
+}
+
+// kill closes the node's STDIN, which stops it once its in-flight handlers
+// return, and drops whatever it writes from now on.
+func (e *endpoint) kill() {
+	e.stdout.stop()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.stdin.Close()
+}
+
+// write sends msg to the node's STDIN.
+func (e *endpoint) write(msg maelstrom.Message) {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.ready && msg.Src != initClient {
+		return
+	}
+
+	// Errors mean the node has been stopped, in which case the message is
+	// simply lost.
+	e.stdin.Write(append(buf, '\n'))
+}
+
+// Service is a built-in maelstrom service, which answers requests itself
+// rather than running as a node.
+type Service interface {
+	// Handle returns the body of the reply to msg.
+	Handle(msg maelstrom.Message) any
+}
+
+// lineWriter is a node's STDOUT. It buffers writes until a full line, which
+// is a single message, has been written and then routes it.
+type lineWriter struct {
+	net *Network
+
+	mu      sync.Mutex
+	buf     []byte
+	stopped bool
+}
+
+// stop discards everything written from now on.
+func (w *lineWriter) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopped = true
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped {
+		return len(p), nil
+	}
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		var msg maelstrom.Message
+		if err := json.Unmarshal(w.buf[:i], &msg); err != nil {
+			return len(p), fmt.Errorf("unmarshal message: %w", err)
+		}
+		w.buf = w.buf[i+1:]
+
+		w.net.send(msg)
+	}
+
+	return len(p), nil
+}
+
+// Client sends requests into the network on behalf of a test.
+type Client struct {
+	id  string
+	net *Network
+
+	mu        sync.Mutex
+	nextMsgID int
+	pending   map[int]chan maelstrom.Message
+}
+
+// ID returns the client's ID.
+func (c *Client) ID() string {
+	return c.id
+}
+
+// RPC sends body to dest and waits for the reply. An error reply is returned
+// as an *RPCError along with the reply message.
+func (c *Client) RPC(ctx context.Context, dest string, body any) (maelstrom.Message, error) {
+	// Fields are kept as raw JSON so that large integers are sent unchanged.
+	b := make(map[string]json.RawMessage)
+	if buf, err := json.Marshal(body); err != nil {
+		return maelstrom.Message{}, err
+	} else if err := json.Unmarshal(buf, &b); err != nil {
+		return maelstrom.Message{}, err
+	}
+
+	replies := make(chan maelstrom.Message, 1)
+
+	c.mu.Lock()
+	c.nextMsgID++
+	msgID := c.nextMsgID
+	c.pending[msgID] = replies
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, msgID)
+		c.mu.Unlock()
+	}()
+
+	b["msg_id"], _ = json.Marshal(msgID)
+	buf, err := json.Marshal(b)
+	if err != nil {
+		return maelstrom.Message{}, err
+	}
+
+	c.net.send(maelstrom.Message{Src: c.id, Dest: dest, Body: buf})
+
+	select {
+	case <-ctx.Done():
+		return maelstrom.Message{}, ctx.Err()
+	case msg := <-replies:
+		if err := msg.RPCError(); err != nil {
+			return msg, err
+		}
+		return msg, nil
+	}
+}
+
+// receive passes a reply to the RPC waiting for it.
+func (c *Client) receive(msg maelstrom.Message) {
+	var body maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	replies, ok := c.pending[body.InReplyTo]
+	c.mu.Unlock()
+
+	if ok {
+		select {
+		case replies <- msg:
+		default:
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// newRelay returns a node that answers "relay" requests by asking the next
+// node in the cluster to "echo" the value back.
+func newRelay() *maelstrom.Node {
+	n := maelstrom.NewNode()
+
+	n.Handle("echo", func(msg maelstrom.Message) error {
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		body["type"] = "echo_ok"
+		body["by"] = n.ID()
+		return n.Reply(msg, body)
+	})
+
+	n.Handle("relay", func(msg maelstrom.Message) error {
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		ids := n.NodeIDs()
+		next := ids[0]
+		for i, id := range ids {
+			if id == n.ID() {
+				next = ids[(i+1)%len(ids)]
+			}
+		}
+
+		resp, err := n.SyncRPC(context.Background(), next, map[string]any{"type": "echo", "value": body["value"]})
+		if err != nil {
+			return err
+		}
+
+		var reply map[string]any
+		if err := json.Unmarshal(resp.Body, &reply); err != nil {
+			return err
+		}
+		reply["type"] = "relay_ok"
+		return n.Reply(msg, reply)
+	})
+
+	return n
+}
+
+func startRelays(t *testing.T, cfg Config, count int) *Network {
+	t.Helper()
+
+	net := NewNetwork(cfg)
+	for i := 0; i < count; i++ {
+		net.AddNode("n"+string(rune('0'+i)), newRelay())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatalf("could not start network: %v", err)
+	}
+	t.Cleanup(net.Close)
+
+	return net
+}
+
+func TestClientRPC(t *testing.T) {
+	net := startRelays(t, Config{}, 3)
+	c := net.Client("c1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, id := range net.NodeIDs() {
+		reply, err := c.RPC(ctx, id, map[string]any{"type": "echo", "value": 42})
+		if err != nil {
+			t.Fatalf("echo %s: %v", id, err)
+		}
+
+		var body map[string]any
+		if err := json.Unmarshal(reply.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		if body["type"] != "echo_ok" || body["value"] != float64(42) || body["by"] != id {
+			t.Errorf("unexpected reply from %s: %v", id, body)
+		}
+	}
+}
+
+func TestNodesTalkToEachOther(t *testing.T) {
+	net := startRelays(t, Config{}, 3)
+	c := net.Client("c1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := c.RPC(ctx, "n2", map[string]any{"type": "relay", "value": 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(reply.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["type"] != "relay_ok" || body["value"] != float64(7) || body["by"] != "n0" {
+		t.Errorf("unexpected reply: %v", body)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	latency := 20 * time.Millisecond
+	net := startRelays(t, Config{Latency: latency}, 2)
+	c := net.Client("c1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// A relay is four hops: client to node, node to node and back again.
+	start := time.Now()
+	if _, err := c.RPC(ctx, "n0", map[string]any{"type": "relay", "value": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 4*latency {
+		t.Errorf("expected at least %v, took %v", 4*latency, elapsed)
+	}
+}
+
+func TestUnknownDestination(t *testing.T) {
+	net := startRelays(t, Config{}, 1)
+	c := net.Client("c1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := c.RPC(ctx, "n9", map[string]any{"type": "echo"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestKillAndRestart(t *testing.T) {
+	net := startRelays(t, Config{}, 2)
+	c := net.Client("c1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	net.Kill("n1")
+
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if _, err := c.RPC(short, "n1", map[string]any{"type": "echo"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a killed node not to reply, got %v", err)
+	}
+
+	if err := net.Restart(ctx, "n1", newRelay()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new node has been initialised and can reach the rest of the cluster.
+	reply, err := c.RPC(ctx, "n1", map[string]any{"type": "relay", "value": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(reply.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["by"] != "n0" {
+		t.Errorf("unexpected reply: %v", body)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package txn
 
 import (
 	"encoding/json"
@@ -41,7 +41,6 @@ func TestDeserialize(t *testing.T) {
 
 func TestSerialize(t *testing.T) {
 	msg := txnResponse{
-		Type: "txn_ok",
 		Transaction: []operation{
 			{operationType: "r", key: 1, value: intptr(3)},
 			{operationType: "w", key: 1, value: intptr(6)},
@@ -54,7 +53,7 @@ func TestSerialize(t *testing.T) {
 		t.Errorf("could not marshal json: %v", err)
 	}
 
-	expectedMsg := `{"type":"txn_ok","txn":[["r",1,3],["w",1,6],["w",2,9]]}`
+	expectedMsg := `{"txn":[["r",1,3],["w",1,6],["w",2,9]]}`
 
 	if string(rawMsg) != expectedMsg {
 		t.Errorf(fmt.Sprintf("expected:\n%v\n\ngot:\n%v", expectedMsg, string(rawMsg)))
@@ -0,0 +1,144 @@
+// Package txn implements the totally-available transactions challenge.
+package txn
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/health"
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/retry"
+	"fly-io-dist-sys/internal/rpc"
+)
+
+// Config controls whether a Server aborts transactions.
+type Config struct {
+	// AbortRate is the fraction of client transactions which are randomly
+	// aborted. This is used to test G1a, but aborted transactions negatively
+	// affect the availability percentage that maelstrom calculates and cause
+	// the test to fail. So it is off by default.
+	AbortRate float64
+}
+
+// DefaultConfig returns the configuration used for challenge 6c.
+func DefaultConfig() Config {
+	return Config{
+		AbortRate: 0,
+	}
+}
+
+// Server is a node in the cluster.
+type Server struct {
+	cfg     Config
+	n       *maelstrom.Node
+	sender  *retry.Sender
+	metrics *metrics.Registry
+	data    map[int]*int
+	dataMu  *sync.Mutex
+}
+
+// NewServer returns a Server handling messages for n.
+func NewServer(n *maelstrom.Node, cfg Config) *Server {
+	s := &Server{
+		cfg:     cfg,
+		n:       n,
+		sender:  retry.NewSender(n, retry.DefaultConfig()),
+		metrics: metrics.For(n),
+		data:    make(map[int]*int),
+		dataMu:  &sync.Mutex{},
+	}
+
+	rpc.Handle(n, "txn", s.txn)
+
+	health.Report(n, "keys", func() int {
+		s.dataMu.Lock()
+		defer s.dataMu.Unlock()
+
+		return len(s.data)
+	})
+
+	return s
+}
+
+func (s *Server) txn(ctx context.Context, body txnRequest) (txnResponse, error) {
+	msg := rpc.Message(ctx)
+
+	s.dataMu.Lock()
+	defer s.dataMu.Unlock()
+
+	txn := make([]operation, 0)
+
+	// take snapshot of relevant keys before processing transaction
+	snapshot := make(map[int]*int)
+	for _, op := range body.Transaction {
+		snapshot[op.key] = s.data[op.key]
+	}
+
+	for _, op := range body.Transaction {
+		switch op.operationType {
+		case read:
+			op.value = s.data[op.key]
+		case write:
+			s.data[op.key] = op.value
+		default:
+			return txnResponse{}, rpc.Errorf(maelstrom.MalformedRequest, "unrecognized operation: %v", op.operationType)
+		}
+
+		txn = append(txn, op)
+	}
+
+	// randomly abort transactions from client messages
+	if isClientMsg(msg) && s.shouldAbort() {
+		// restore data from snapshot
+		for k, v := range snapshot {
+			s.data[k] = v
+		}
+
+		s.metrics.Counter("txn.aborted").Inc()
+		return txnResponse{}, rpc.Errorf(maelstrom.TxnConflict, "txn abort")
+	}
+
+	// replicate to other nodes if transaction comes from a client
+	if isClientMsg(msg) {
+		for _, nId := range s.n.NodeIDs() {
+			// do not send to self
+			if s.n.ID() == nId {
+				continue
+			}
+
+			s.replicate(nId, body)
+		}
+	}
+
+	return txnResponse{Transaction: txn}, nil
+}
+
+// replicate sends a client transaction on to another node in the background.
+func (s *Server) replicate(dst string, body txnRequest) {
+	s.metrics.Counter("txn.replicated").Inc()
+	s.metrics.Gauge("txn.replicating").Add(1)
+
+	start := time.Now()
+	s.sender.Send(context.Background(), dst, body, func(reply maelstrom.Message, err error) {
+		s.metrics.Gauge("txn.replicating").Add(-1)
+		if err != nil {
+			s.metrics.Counter("txn.replication_failures").Inc()
+			return
+		}
+		s.metrics.Histogram("txn.replication_latency").Since(start)
+	})
+}
+
+// returns true for roughly the configured fraction of transactions
+func (s *Server) shouldAbort() bool {
+	return rand.Float64() < s.cfg.AbortRate
+}
+
+func isClientMsg(msg maelstrom.Message) bool {
+	return strings.HasPrefix(msg.Src, "c")
+}
@@ -0,0 +1,175 @@
+package txn
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+func TestTransactionsReplicate(t *testing.T) {
+	net := sim.NewNetwork(sim.Config{Latency: 5 * time.Millisecond})
+	for _, id := range []string{"n0", "n1"} {
+		net.AddNode(id, NewServer(maelstrom.NewNode(), DefaultConfig()).n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	c := net.Client("c1")
+
+	writeTxn := txnRequest{
+		Type: "txn",
+		Transaction: []operation{
+			{operationType: write, key: 1, value: intptr(6)},
+			{operationType: read, key: 1},
+		},
+	}
+	reply, err := c.RPC(ctx, "n0", writeTxn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp txnRequest
+	if err := json.Unmarshal(reply.Body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	expected := []operation{
+		{operationType: write, key: 1, value: intptr(6)},
+		{operationType: read, key: 1, value: intptr(6)},
+	}
+	if !reflect.DeepEqual(resp.Transaction, expected) {
+		t.Fatalf("expected %v, got %v", expected, resp.Transaction)
+	}
+
+	// Wait for the write to be replicated.
+	time.Sleep(50 * time.Millisecond)
+
+	readTxn := txnRequest{
+		Type:        "txn",
+		Transaction: []operation{{operationType: read, key: 1}},
+	}
+	reply, err = c.RPC(ctx, "n1", readTxn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(reply.Body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	expected = []operation{{operationType: read, key: 1, value: intptr(6)}}
+	if !reflect.DeepEqual(resp.Transaction, expected) {
+		t.Fatalf("expected %v, got %v", expected, resp.Transaction)
+	}
+}
+
+func TestTransactionsReplicateAfterPartitions(t *testing.T) {
+	const seed = 6
+
+	net := sim.NewNetwork(sim.Config{Latency: 2 * time.Millisecond, Seed: seed})
+	for _, id := range []string{"n0", "n1", "n2"} {
+		net.AddNode(id, NewServer(maelstrom.NewNode(), DefaultConfig()).n)
+	}
+
+	nem := sim.NewNemesis(net, sim.NemesisConfig{
+		Seed:          seed,
+		Faults:        []sim.Fault{sim.PartitionMajority, sim.PartitionBridge, sim.Pause},
+		Interval:      30 * time.Millisecond,
+		DuplicateRate: 0.1,
+		ReorderRate:   0.2,
+		ReorderDelay:  20 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	faults, stopFaults := context.WithCancel(ctx)
+	go nem.Run(faults)
+
+	c := net.Client("c1")
+
+	// Each write is to its own key, so the order of replication does not
+	// matter.
+	for key := 0; key < 20; key++ {
+		txn := txnRequest{
+			Type:        "txn",
+			Transaction: []operation{{operationType: write, key: key, value: intptr(key * 10)}},
+		}
+		if _, err := c.RPC(ctx, net.NodeIDs()[key%3], txn); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	stopFaults()
+
+	for _, id := range net.NodeIDs() {
+		for key := 0; key < 20; key++ {
+			for {
+				txn := txnRequest{
+					Type:        "txn",
+					Transaction: []operation{{operationType: read, key: key}},
+				}
+				reply, err := c.RPC(ctx, id, txn)
+				if err != nil {
+					t.Fatalf("%v (nemesis schedule: %v)", err, nem.History())
+				}
+
+				var resp txnResponse
+				if err := json.Unmarshal(reply.Body, &resp); err != nil {
+					t.Fatal(err)
+				}
+				if v := resp.Transaction[0].value; v != nil && *v == key*10 {
+					break
+				}
+
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+}
+
+func TestAbortedTransactionsRollBack(t *testing.T) {
+	s := NewServer(maelstrom.NewNode(), Config{AbortRate: 1})
+
+	net := sim.NewNetwork(sim.Config{})
+	net.AddNode("n0", s.n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	writeTxn := txnRequest{
+		Type:        "txn",
+		Transaction: []operation{{operationType: write, key: 1, value: intptr(6)}},
+	}
+	if _, err := net.Client("c1").RPC(ctx, "n0", writeTxn); maelstrom.ErrorCode(err) != maelstrom.TxnConflict {
+		t.Fatalf("expected txn conflict, got %v", err)
+	}
+
+	s.dataMu.Lock()
+	defer s.dataMu.Unlock()
+
+	if v := s.data[1]; v != nil {
+		t.Fatalf("expected write to be rolled back, got %v", *v)
+	}
+}
@@ -0,0 +1,68 @@
+package uniqueids
+
+import (
+	"context"
+	"fmt"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// denseKey is the lin-kv key holding the next value no node has leased yet.
+const denseKey = "unique-ids/next"
+
+// lease is a block of dense IDs [next, end) which only this node may issue.
+type lease struct {
+	next, end int
+}
+
+// nextDense returns the next dense ID, leasing a new block from lin-kv if the
+// current one is used up. It must be called with counterLock held.
+//
+// Leasing needs lin-kv to be reachable, so a node cut off from it can only
+// issue what is left of its current block, and then fails every request until
+// the partition heals. IDs left in a block when a node restarts are never
+// issued, so gaps are bounded by LeaseSize per restart.
+func (s *Server) nextDense() (int, error) {
+	if s.lease.next >= s.lease.end {
+		l, err := s.leaseBlock()
+		if err != nil {
+			return 0, err
+		}
+		s.lease = l
+	}
+
+	id := s.lease.next
+	s.lease.next++
+	return id, nil
+}
+
+// leaseBlock takes the next LeaseSize values from the shared counter in
+// lin-kv, retrying if another node takes them first.
+func (s *Server) leaseBlock() (lease, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.LeaseTimeout)
+	defer cancel()
+
+	size := max(s.cfg.LeaseSize, 1)
+	for {
+		start, err := s.linKV.ReadInt(ctx, denseKey)
+		if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+			start, err = 0, nil
+		}
+		if err != nil {
+			return lease{}, fmt.Errorf("read %s: %w", denseKey, err)
+		}
+
+		err = s.linKV.CompareAndSwap(ctx, denseKey, start, start+size, true)
+		if maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
+			continue
+		}
+		if err != nil {
+			// the block may or may not have been taken, but either way it is
+			// not used, so the only cost is a gap
+			return lease{}, fmt.Errorf("lease %s: %w", denseKey, err)
+		}
+
+		s.metrics.Counter("uniqueids.leases").Inc()
+		return lease{next: start, end: start + size}, nil
+	}
+}
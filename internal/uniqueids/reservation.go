@@ -0,0 +1,78 @@
+package uniqueids
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// highWaterMark is a value persisted to a file, which is only rewritten once
+// the new value is safely on disk.
+//
+// The counter reserves IDs in blocks by storing the end of the block before
+// handing out any ID in it. After a restart, it carries on from the stored
+// value, which is above every ID the previous process could have issued.
+type highWaterMark struct {
+	path string
+}
+
+// load returns the stored value, or 0 if nothing has been stored yet.
+func (h highWaterMark) load() (int, error) {
+	buf, err := os.ReadFile(h.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", h.path, err)
+	}
+	return v, nil
+}
+
+// store replaces the stored value with v.
+//
+// v is written to a temporary file which is synced and renamed over the old
+// one, so a crash leaves either the old value or the new one, never a torn
+// write. The directory is synced too, so that the rename itself is durable.
+func (h highWaterMark) store(v int) error {
+	dir := filepath.Dir(h.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp := h.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strconv.Itoa(v) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
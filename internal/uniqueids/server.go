@@ -0,0 +1,356 @@
+// Package uniqueids implements the unique ID generation challenge.
+package uniqueids
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/metrics"
+	"fly-io-dist-sys/internal/rpc"
+)
+
+// Mode selects the kind of ID a Server generates.
+type Mode string
+
+const (
+	// ModeCounter generates string IDs of the form "n1-42", from the node ID
+	// and a per-node counter.
+	ModeCounter Mode = "counter"
+
+	// ModeSnowflake generates 64-bit numeric IDs from the time, the index of
+	// the node in the cluster and a per-millisecond sequence.
+	ModeSnowflake Mode = "snowflake"
+
+	// ModeULID generates 26 character ULID strings, which sort by the time
+	// they were generated.
+	ModeULID Mode = "ulid"
+
+	// ModeUUIDv7 generates version 7 UUID strings, which sort by the time they
+	// were generated and fit standard UUID columns.
+	ModeUUIDv7 Mode = "uuidv7"
+
+	// ModeDense generates small, cluster-wide increasing integers from blocks
+	// leased from lin-kv. Unlike the other modes, it is unavailable to a node
+	// which cannot reach lin-kv.
+	ModeDense Mode = "dense"
+)
+
+// Config controls the IDs a Server generates.
+type Config struct {
+	// Mode is the kind of ID generated for requests which do not ask for a
+	// format of their own.
+	Mode Mode
+
+	// MaxClockRewind is how far the clock may go backwards in snowflake mode
+	// before generate fails, rather than waiting for it to catch up.
+	MaxClockRewind time.Duration
+
+	// StateDir is where counter mode persists how far it has counted, so that
+	// a restarted node does not reissue IDs. If empty, the counter is only
+	// kept in memory and starts from 0 on every restart.
+	StateDir string
+
+	// BlockSize is how many IDs counter mode reserves each time it writes to
+	// StateDir. Up to this many IDs are skipped after a restart.
+	BlockSize int
+
+	// MaxBatchSize is the most IDs a single generate_batch or generate_range
+	// request may ask for.
+	MaxBatchSize int
+
+	// LeaseSize is how many IDs dense mode leases from lin-kv at a time.
+	// Larger leases mean fewer trips to lin-kv, but larger gaps when a node
+	// restarts and IDs which are less ordered across nodes.
+	LeaseSize int
+
+	// LeaseTimeout is how long dense mode waits for lin-kv before giving up
+	// on a lease.
+	LeaseTimeout time.Duration
+}
+
+// DefaultConfig returns the configuration used for challenge 2.
+func DefaultConfig() Config {
+	return Config{
+		Mode:           ModeCounter,
+		MaxClockRewind: time.Second,
+		BlockSize:      1000,
+		MaxBatchSize:   10000,
+		LeaseSize:      100,
+		LeaseTimeout:   time.Second,
+	}
+}
+
+type generateRequest struct {
+	Type string `json:"type"`
+
+	// Format overrides the configured mode for this request.
+	Format Mode `json:"format,omitempty"`
+}
+
+type generateResponse struct {
+	// ID is a number in snowflake and dense mode, and a string otherwise.
+	ID any `json:"id"`
+}
+
+type generateBatchRequest struct {
+	Type   string `json:"type"`
+	Count  int    `json:"count"`
+	Format Mode   `json:"format,omitempty"`
+}
+
+type generateBatchResponse struct {
+	IDs []any `json:"ids"`
+}
+
+type generateRangeRequest struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// generateRangeResponse hands the client the counter values [Start, End),
+// which it turns into the IDs "<node>-<start>" up to "<node>-<end-1>" itself.
+type generateRangeResponse struct {
+	Node  string `json:"node"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Server is a node in the cluster.
+type Server struct {
+	cfg         Config
+	n           *maelstrom.Node
+	linKV       *maelstrom.KV
+	metrics     *metrics.Registry
+	counter     int
+	counterLock *sync.Mutex
+
+	// reserved is the end of the block of IDs the counter may issue before
+	// it has to reserve another. It is only used when cfg.StateDir is set.
+	reserved int
+	loaded   bool
+
+	// lease is the block dense mode is issuing IDs from.
+	lease lease
+
+	// snowflake is created on the first generate in snowflake mode, since the
+	// node index is not known until the node is initialised.
+	snowflake *snowflake
+
+	ulid   *sortable
+	uuidv7 *sortable
+}
+
+// NewServer returns a Server handling messages for n.
+func NewServer(n *maelstrom.Node, cfg Config) *Server {
+	s := &Server{
+		cfg:         cfg,
+		n:           n,
+		linKV:       maelstrom.NewLinKV(n),
+		metrics:     metrics.For(n),
+		counter:     0,
+		counterLock: &sync.Mutex{},
+		ulid:        newSortable(80),
+		uuidv7:      newSortable(74),
+	}
+
+	rpc.Handle(n, "generate", s.generate)
+	rpc.Handle(n, "generate_batch", s.generateBatch)
+	rpc.Handle(n, "generate_range", s.generateRange)
+
+	return s
+}
+
+func (s *Server) generate(ctx context.Context, req generateRequest) (generateResponse, error) {
+	ids, err := s.ids(s.mode(req.Format), 1)
+	if err != nil {
+		return generateResponse{}, err
+	}
+	return generateResponse{ID: ids[0]}, nil
+}
+
+func (s *Server) generateBatch(ctx context.Context, req generateBatchRequest) (generateBatchResponse, error) {
+	if err := s.checkCount(req.Count); err != nil {
+		return generateBatchResponse{}, err
+	}
+
+	ids, err := s.ids(s.mode(req.Format), req.Count)
+	if err != nil {
+		return generateBatchResponse{}, err
+	}
+	return generateBatchResponse{IDs: ids}, nil
+}
+
+func (s *Server) generateRange(ctx context.Context, req generateRangeRequest) (generateRangeResponse, error) {
+	if err := s.checkCount(req.Count); err != nil {
+		return generateRangeResponse{}, err
+	}
+	if s.cfg.Mode != ModeCounter {
+		return generateRangeResponse{}, rpc.Errorf(maelstrom.NotSupported, "ranges are only generated in counter mode, not %s", s.cfg.Mode)
+	}
+
+	s.counterLock.Lock()
+	defer s.counterLock.Unlock()
+
+	if err := s.reserve(req.Count); err != nil {
+		return generateRangeResponse{}, rpc.Errorf(maelstrom.TemporarilyUnavailable, "reserve IDs: %v", err)
+	}
+
+	start := s.counter
+	s.counter += req.Count
+
+	return generateRangeResponse{Node: s.n.ID(), Start: start, End: s.counter}, nil
+}
+
+// mode returns the mode a request asks for, or the configured one if it does
+// not ask for any.
+func (s *Server) mode(format Mode) Mode {
+	if format != "" {
+		return format
+	}
+	return s.cfg.Mode
+}
+
+func (s *Server) checkCount(count int) error {
+	if count < 1 || count > s.cfg.MaxBatchSize {
+		return rpc.Errorf(maelstrom.MalformedRequest, "count must be between 1 and %d, got %d", s.cfg.MaxBatchSize, count)
+	}
+	return nil
+}
+
+// ids generates count IDs of the given mode. Counter IDs are allocated in one
+// go, so they are contiguous.
+func (s *Server) ids(mode Mode, count int) ([]any, error) {
+	ids := make([]any, 0, count)
+
+	switch mode {
+	case ModeCounter:
+		s.counterLock.Lock()
+		defer s.counterLock.Unlock()
+
+		if err := s.reserve(count); err != nil {
+			// no ID has been issued, so the client can safely try again
+			return nil, rpc.Errorf(maelstrom.TemporarilyUnavailable, "reserve IDs: %v", err)
+		}
+
+		for i := 0; i < count; i++ {
+			ids = append(ids, fmt.Sprintf("%s-%d", s.n.ID(), s.counter))
+			s.counter++
+		}
+		return ids, nil
+
+	case ModeSnowflake:
+		g, err := s.snowflakeGenerator()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < count; i++ {
+			id, err := g.next()
+			if errors.Is(err, errClockRewound) {
+				return nil, rpc.Errorf(maelstrom.TemporarilyUnavailable, "generate: %v", err)
+			}
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+
+	case ModeULID:
+		for i := 0; i < count; i++ {
+			ms, hi, lo, err := s.ulid.next()
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, ulid(ms, hi, lo))
+		}
+		return ids, nil
+
+	case ModeUUIDv7:
+		for i := 0; i < count; i++ {
+			ms, hi, lo, err := s.uuidv7.next()
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, uuidv7(ms, hi, lo))
+		}
+		return ids, nil
+
+	case ModeDense:
+		s.counterLock.Lock()
+		defer s.counterLock.Unlock()
+
+		for i := 0; i < count; i++ {
+			id, err := s.nextDense()
+			if err != nil {
+				// IDs already taken from the block are not handed out, but
+				// are not issued again either
+				return nil, rpc.Errorf(maelstrom.TemporarilyUnavailable, "generate: %v", err)
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+
+	default:
+		return nil, rpc.Errorf(maelstrom.NotSupported, "unknown ID mode %q", mode)
+	}
+}
+
+// reserve makes sure the counter's next count values have been durably
+// reserved, so that they are never issued again after a restart. It must be
+// called with counterLock held.
+func (s *Server) reserve(count int) error {
+	if s.cfg.StateDir == "" {
+		return nil
+	}
+
+	hwm := highWaterMark{path: filepath.Join(s.cfg.StateDir, s.n.ID()+".ids")}
+
+	if !s.loaded {
+		// carry on from the end of the last block a previous process reserved
+		v, err := hwm.load()
+		if err != nil {
+			return err
+		}
+		s.counter, s.reserved, s.loaded = v, v, true
+	}
+
+	if s.counter+count <= s.reserved {
+		return nil
+	}
+
+	next := s.counter + max(s.cfg.BlockSize, count)
+	if err := hwm.store(next); err != nil {
+		return err
+	}
+	s.reserved = next
+	return nil
+}
+
+func (s *Server) snowflakeGenerator() (*snowflake, error) {
+	s.counterLock.Lock()
+	defer s.counterLock.Unlock()
+
+	if s.snowflake != nil {
+		return s.snowflake, nil
+	}
+
+	node := slices.Index(s.n.NodeIDs(), s.n.ID())
+	if node < 0 {
+		return nil, fmt.Errorf("node %s is not in the cluster", s.n.ID())
+	}
+
+	g, err := newSnowflake(node, s.cfg.MaxClockRewind)
+	if err != nil {
+		return nil, err
+	}
+	s.snowflake = g
+	return g, nil
+}
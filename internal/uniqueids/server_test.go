@@ -0,0 +1,540 @@
+package uniqueids
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+
+	"fly-io-dist-sys/internal/sim"
+)
+
+// fakeClock is a clock which only moves when told to, or when slept on.
+type fakeClock struct {
+	t     time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) sleep(d time.Duration) {
+	c.t = c.t.Add(d)
+	c.slept += d
+}
+
+func newFakeSnowflake(t *testing.T, node int, maxRewind time.Duration) (*snowflake, *fakeClock) {
+	t.Helper()
+
+	g, err := newSnowflake(node, maxRewind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &fakeClock{t: epoch.Add(time.Hour)}
+	g.now, g.sleep = c.now, c.sleep
+	return g, c
+}
+
+func TestSnowflakeLayout(t *testing.T) {
+	g, _ := newFakeSnowflake(t, 5, 0)
+
+	id, err := g.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ts := id >> (nodeBits + sequenceBits); ts != time.Hour.Milliseconds() {
+		t.Errorf("expected timestamp %d, got %d", time.Hour.Milliseconds(), ts)
+	}
+	if node := id >> sequenceBits & maxNode; node != 5 {
+		t.Errorf("expected node 5, got %d", node)
+	}
+	if seq := id & maxSequence; seq != 0 {
+		t.Errorf("expected sequence 0, got %d", seq)
+	}
+}
+
+func TestSnowflakeExhaustedSequence(t *testing.T) {
+	g, c := newFakeSnowflake(t, 0, 0)
+
+	// The clock never moves by itself, so every ID after the first 4096 has
+	// to wait for the next millisecond.
+	var last int64 = -1
+	for i := 0; i < 3*(maxSequence+1); i++ {
+		id, err := g.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= last {
+			t.Fatalf("expected IDs to increase, got %d after %d", id, last)
+		}
+		last = id
+	}
+
+	if c.slept != 2*time.Millisecond {
+		t.Fatalf("expected to wait 2ms, got %v", c.slept)
+	}
+}
+
+func TestSnowflakeClockRewind(t *testing.T) {
+	g, c := newFakeSnowflake(t, 0, 10*time.Millisecond)
+
+	first, err := g.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A small step backwards is waited out.
+	c.t = c.t.Add(-5 * time.Millisecond)
+	second, err := g.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second <= first {
+		t.Fatalf("expected IDs to increase, got %d after %d", second, first)
+	}
+	if c.slept != 5*time.Millisecond {
+		t.Fatalf("expected to wait 5ms, got %v", c.slept)
+	}
+
+	// A large one is refused.
+	c.t = c.t.Add(-time.Second)
+	if _, err := g.next(); !errors.Is(err, errClockRewound) {
+		t.Fatalf("expected the clock to be rewound, got %v", err)
+	}
+}
+
+func TestSnowflakeNodeOutOfRange(t *testing.T) {
+	if _, err := newSnowflake(maxNode+1, 0); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestGenerateSnowflake(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Mode = ModeSnowflake
+
+	net := sim.NewNetwork(sim.Config{})
+	for _, id := range []string{"n0", "n1", "n2"} {
+		net.AddNode(id, NewServer(maelstrom.NewNode(), cfg).n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	c := net.Client("c1")
+
+	seen := make(map[int64]bool)
+	for i := 0; i < 300; i++ {
+		id := net.NodeIDs()[i%3]
+		reply, err := c.RPC(ctx, id, generateRequest{Type: "generate"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resp struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(reply.Body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if seen[resp.ID] {
+			t.Fatalf("%s: duplicate ID %d", id, resp.ID)
+		}
+		seen[resp.ID] = true
+
+		if node := resp.ID >> sequenceBits & maxNode; net.NodeIDs()[node] != id {
+			t.Fatalf("expected ID from %s, got node index %d", id, node)
+		}
+	}
+}
+
+func TestCounterSurvivesRestart(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.BlockSize = 10
+
+	net := sim.NewNetwork(sim.Config{Latency: time.Millisecond})
+	for _, id := range []string{"n0", "n1"} {
+		net.AddNode(id, NewServer(maelstrom.NewNode(), cfg).n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	// Clients keep generating IDs while n1 is killed and restarted, so some
+	// requests are lost along with the node.
+	var (
+		ids   []string
+		idsMu sync.Mutex
+	)
+	record := func(id string) {
+		idsMu.Lock()
+		defer idsMu.Unlock()
+
+		ids = append(ids, id)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, client := range []string{"c1", "c2", "c3"} {
+		wg.Add(1)
+		go func(c *sim.Client, dest string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				rctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+				reply, err := c.RPC(rctx, dest, generateRequest{Type: "generate"})
+				cancel()
+				if err != nil {
+					continue
+				}
+
+				var resp struct {
+					ID string `json:"id"`
+				}
+				if err := json.Unmarshal(reply.Body, &resp); err != nil {
+					t.Error(err)
+					return
+				}
+				record(resp.ID)
+			}
+		}(net.Client(client), net.NodeIDs()[i%2])
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if err := net.Restart(ctx, "n1", NewServer(maelstrom.NewNode(), cfg).n); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the restarted n1 to issue an ID from a block it reserved after
+	// the restart, rather than sleeping for long enough and hoping.
+	c := net.Client("c4")
+	for {
+		if ctx.Err() != nil {
+			t.Fatal("expected IDs from the restarted n1")
+		}
+
+		rctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		reply, err := c.RPC(rctx, "n1", generateRequest{Type: "generate"})
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		var resp struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(reply.Body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		record(resp.ID)
+
+		var n int
+		if _, err := fmt.Sscanf(resp.ID, "n1-%d", &n); err == nil && n >= cfg.BlockSize {
+			break
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate ID %s", id)
+		}
+		seen[id] = true
+	}
+
+	// n1 carried on past the block it had reserved before the restart, rather
+	// than starting again from 0.
+	if !seen["n1-0"] {
+		t.Fatalf("expected n1 to have issued n1-0, got %v", seen)
+	}
+	restarted := 0
+	for id := range seen {
+		var n int
+		if _, err := fmt.Sscanf(id, "n1-%d", &n); err == nil && n >= cfg.BlockSize {
+			restarted++
+		}
+	}
+	if restarted == 0 {
+		t.Fatalf("expected IDs from the restarted n1, got %v", seen)
+	}
+}
+
+func TestGenerateFormat(t *testing.T) {
+	net := sim.NewNetwork(sim.Config{})
+	net.AddNode("n0", NewServer(maelstrom.NewNode(), DefaultConfig()).n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	c := net.Client("c1")
+
+	generate := func(format Mode) (string, error) {
+		reply, err := c.RPC(ctx, "n0", generateRequest{Type: "generate", Format: format})
+		if err != nil {
+			return "", err
+		}
+
+		var resp struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(reply.Body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.ID, nil
+	}
+
+	for _, tc := range []struct {
+		format Mode
+		length int
+	}{
+		{ModeULID, 26},
+		{ModeUUIDv7, 36},
+	} {
+		var last string
+		for i := 0; i < 100; i++ {
+			id, err := generate(tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(id) != tc.length {
+				t.Fatalf("expected a %d character %s, got %q", tc.length, tc.format, id)
+			}
+			if id <= last {
+				t.Fatalf("expected %s IDs to increase, got %s after %s", tc.format, id, last)
+			}
+			last = id
+		}
+	}
+
+	// without a format, the configured mode is used
+	if id, err := generate(""); err != nil || id != "n0-0" {
+		t.Fatalf("expected n0-0, got %q, %v", id, err)
+	}
+
+	if _, err := generate("uuidv4"); maelstrom.ErrorCode(err) != maelstrom.NotSupported {
+		t.Fatalf("expected not supported, got %v", err)
+	}
+}
+
+func TestGenerateBatchAndRange(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.BlockSize = 10
+	cfg.MaxBatchSize = 100
+
+	net := sim.NewNetwork(sim.Config{})
+	net.AddNode("n0", NewServer(maelstrom.NewNode(), cfg).n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+
+	c := net.Client("c1")
+
+	if _, err := c.RPC(ctx, "n0", generateRequest{Type: "generate"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a batch larger than a block reserves enough for the whole batch
+	reply, err := c.RPC(ctx, "n0", generateBatchRequest{Type: "generate_batch", Count: 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.Unmarshal(reply.Body, &batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(batch.IDs) != 25 || batch.IDs[0] != "n0-1" || batch.IDs[24] != "n0-25" {
+		t.Fatalf("expected n0-1 to n0-25, got %v", batch.IDs)
+	}
+
+	reply, err = c.RPC(ctx, "n0", generateRangeRequest{Type: "generate_range", Count: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rng generateRangeResponse
+	if err := json.Unmarshal(reply.Body, &rng); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (generateRangeResponse{Node: "n0", Start: 26, End: 76}); rng != expected {
+		t.Fatalf("expected %+v, got %+v", expected, rng)
+	}
+
+	// the range was reserved before it was handed out
+	hwm, err := highWaterMark{path: filepath.Join(cfg.StateDir, "n0.ids")}.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hwm < rng.End {
+		t.Fatalf("expected at least %d to be reserved, got %d", rng.End, hwm)
+	}
+
+	reply, err = c.RPC(ctx, "n0", generateBatchRequest{Type: "generate_batch", Count: 3, Format: ModeULID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(reply.Body, &batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(batch.IDs) != 3 || !(batch.IDs[0] < batch.IDs[1] && batch.IDs[1] < batch.IDs[2]) {
+		t.Fatalf("expected 3 increasing ULIDs, got %v", batch.IDs)
+	}
+
+	for _, count := range []int{0, -1, 101} {
+		_, err := c.RPC(ctx, "n0", generateBatchRequest{Type: "generate_batch", Count: count})
+		if code := maelstrom.ErrorCode(err); code != maelstrom.MalformedRequest {
+			t.Fatalf("count %d: expected malformed request, got %v", count, err)
+		}
+	}
+}
+
+// startDense starts nodes n0 to n2 in dense mode, with leases of the given
+// size.
+func startDense(t *testing.T, leaseSize int) (context.Context, *sim.Network) {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeDense
+	cfg.LeaseSize = leaseSize
+	cfg.LeaseTimeout = 100 * time.Millisecond
+
+	net := sim.NewNetwork(sim.Config{Latency: time.Millisecond})
+	net.AddService(maelstrom.LinKV, sim.NewKV(maelstrom.LinKV, sim.KVConfig{}))
+	for _, id := range []string{"n0", "n1", "n2"} {
+		net.AddNode(id, NewServer(maelstrom.NewNode(), cfg).n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	if err := net.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(net.Close)
+
+	return ctx, net
+}
+
+func generateDense(ctx context.Context, c *sim.Client, dest string) (int, error) {
+	reply, err := c.RPC(ctx, dest, generateRequest{Type: "generate"})
+	if err != nil {
+		return 0, err
+	}
+
+	var resp struct {
+		ID int `json:"id"`
+	}
+	err = json.Unmarshal(reply.Body, &resp)
+	return resp.ID, err
+}
+
+func TestDenseIDs(t *testing.T) {
+	const leaseSize = 10
+	ctx, net := startDense(t, leaseSize)
+
+	// concurrent clients, so that nodes race each other for leases
+	ids := make(chan int, 300)
+	var wg sync.WaitGroup
+	for i, id := range net.NodeIDs() {
+		wg.Add(1)
+		go func(c *sim.Client, dest string) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id, err := generateDense(ctx, c, dest)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids <- id
+			}
+		}(net.Client(fmt.Sprintf("c%d", i+1)), id)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	largest := 0
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate ID %d", id)
+		}
+		seen[id] = true
+		largest = max(largest, id)
+	}
+
+	// the only gaps are what is left of each node's current lease
+	if limit := len(seen) + len(net.NodeIDs())*leaseSize; largest >= limit {
+		t.Fatalf("expected IDs below %d, got %d", limit, largest)
+	}
+}
+
+func TestDenseIsUnavailableWithoutLinKV(t *testing.T) {
+	ctx, net := startDense(t, 2)
+	c := net.Client("c1")
+
+	for _, expected := range []int{0, 1} {
+		if id, err := generateDense(ctx, c, "n0"); err != nil || id != expected {
+			t.Fatalf("expected %d, got %d, %v", expected, id, err)
+		}
+	}
+
+	// n0 has used up its lease, and cannot get another
+	nem := sim.NewNemesis(net, sim.NemesisConfig{})
+	nem.Apply(sim.Step{Fault: sim.PartitionIsolated, Groups: [][]string{{"n0"}, {maelstrom.LinKV}}})
+
+	if _, err := generateDense(ctx, c, "n0"); maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("expected temporarily unavailable, got %v", err)
+	}
+
+	// counter IDs need no coordination, so n0 can still issue them
+	if _, err := c.RPC(ctx, "n0", generateRequest{Type: "generate", Format: ModeCounter}); err != nil {
+		t.Fatal(err)
+	}
+
+	// nodes which can reach lin-kv are unaffected, and n0 recovers once the
+	// partition heals
+	if id, err := generateDense(ctx, c, "n1"); err != nil || id < 2 {
+		t.Fatalf("expected an ID after n0's lease, got %d, %v", id, err)
+	}
+
+	nem.Apply(sim.Step{Fault: sim.Heal})
+	if _, err := generateDense(ctx, c, "n0"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,109 @@
+package uniqueids
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Snowflake IDs are 63 bits wide, so they are always positive as an int64:
+//
+//	| 41 bits timestamp | 10 bits node index | 12 bits sequence |
+//
+// The timestamp is milliseconds since epoch, which lasts until 2093.
+const (
+	timestampBits = 41
+	nodeBits      = 10
+	sequenceBits  = 12
+
+	maxNode     = 1<<nodeBits - 1
+	maxSequence = 1<<sequenceBits - 1
+)
+
+var epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// errClockRewound is returned when the clock has gone backwards by more than
+// the generator is willing to wait out.
+var errClockRewound = errors.New("clock moved backwards")
+
+// snowflake generates time-ordered 64-bit IDs. IDs from one generator are
+// strictly increasing, and generators with different node indexes never
+// produce the same ID.
+type snowflake struct {
+	node int64
+
+	// maxRewind is how far the clock may go backwards before next gives up
+	// rather than waiting for it to catch up.
+	maxRewind time.Duration
+
+	// now and sleep are replaced in tests.
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	mu       sync.Mutex
+	last     int64
+	sequence int64
+}
+
+func newSnowflake(node int, maxRewind time.Duration) (*snowflake, error) {
+	if node < 0 || node > maxNode {
+		return nil, fmt.Errorf("node index %d does not fit in %d bits", node, nodeBits)
+	}
+
+	return &snowflake{
+		node:      int64(node),
+		maxRewind: maxRewind,
+		now:       time.Now,
+		sleep:     time.Sleep,
+	}, nil
+}
+
+// next returns the next ID.
+//
+// Up to 4096 IDs are issued per millisecond. Once they are used up, next waits
+// for the following millisecond. If the clock goes backwards, next waits for
+// it to pass the last timestamp used, so that IDs keep increasing.
+func (g *snowflake) next() (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ts := g.millis()
+	if ts < g.last {
+		behind := time.Duration(g.last-ts) * time.Millisecond
+		if behind > g.maxRewind {
+			return 0, fmt.Errorf("%w by %v", errClockRewound, behind)
+		}
+		ts = g.waitUntil(g.last)
+	}
+
+	if ts == g.last {
+		g.sequence++
+		if g.sequence > maxSequence {
+			// every sequence number in this millisecond has been used
+			ts = g.waitUntil(g.last + 1)
+			g.sequence = 0
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.last = ts
+
+	return ts<<(nodeBits+sequenceBits) | g.node<<sequenceBits | g.sequence, nil
+}
+
+// millis returns the current time in milliseconds since epoch.
+func (g *snowflake) millis() int64 {
+	return g.now().Sub(epoch).Milliseconds()
+}
+
+// waitUntil sleeps until the clock reaches ts, and returns the time then.
+func (g *snowflake) waitUntil(ts int64) int64 {
+	for {
+		now := g.millis()
+		if now >= ts {
+			return now
+		}
+		g.sleep(time.Duration(ts-now) * time.Millisecond)
+	}
+}
@@ -0,0 +1,119 @@
+package uniqueids
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// sortable generates 128-bit IDs made of a 48-bit Unix timestamp in
+// milliseconds followed by random bits, as used by ULIDs and UUIDv7.
+//
+// Random bits make IDs from different nodes unique without any coordination.
+// Within a node, IDs are strictly increasing: the random bits are only drawn
+// for the first ID in a millisecond, and incremented for every other one.
+type sortable struct {
+	// bits is the number of random bits, at most 80.
+	bits int
+
+	// now and random are replaced in tests.
+	now    func() time.Time
+	random io.Reader
+
+	mu   sync.Mutex
+	last int64
+
+	// hi and lo hold the random bits of the last ID, hi being the top
+	// bits-64 of them.
+	hi, lo uint64
+}
+
+func newSortable(bits int) *sortable {
+	return &sortable{
+		bits:   bits,
+		now:    time.Now,
+		random: rand.Reader,
+	}
+}
+
+// next returns the timestamp and random bits of the next ID.
+//
+// If the clock has not moved on, or has gone backwards, the last timestamp is
+// reused and the random bits incremented. Should they overflow, the timestamp
+// is moved on by a millisecond rather than waiting for the clock to get there,
+// so that next never blocks.
+func (g *sortable) next() (ms int64, hi, lo uint64, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms = g.now().UnixMilli()
+	if ms > g.last {
+		if err := g.seed(); err != nil {
+			return 0, 0, 0, err
+		}
+		g.last = ms
+		return g.last, g.hi, g.lo, nil
+	}
+
+	g.lo++
+	if g.lo == 0 {
+		g.hi++
+	}
+	if g.hi >= 1<<(g.bits-64) {
+		// every ID left in this millisecond has been used
+		if err := g.seed(); err != nil {
+			return 0, 0, 0, err
+		}
+		g.last++
+	}
+	return g.last, g.hi, g.lo, nil
+}
+
+// seed draws new random bits.
+func (g *sortable) seed() error {
+	var buf [16]byte
+	if _, err := io.ReadFull(g.random, buf[:]); err != nil {
+		return fmt.Errorf("read random bits: %w", err)
+	}
+
+	g.hi = binary.BigEndian.Uint64(buf[:8]) & (1<<(g.bits-64) - 1)
+	g.lo = binary.BigEndian.Uint64(buf[8:])
+
+	// leave the top random bit clear, so that at least half the range is
+	// left for incrementing before the millisecond overflows
+	g.hi &^= 1 << (g.bits - 65)
+	return nil
+}
+
+// crockford is the alphabet ULIDs are encoded in, which leaves out I, L, O and
+// U to avoid confusion.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulid encodes a timestamp and 80 random bits as a 26 character ULID. ULIDs
+// sort lexically in the same order as their timestamps.
+func ulid(ms int64, hi, lo uint64) string {
+	// the 128-bit value, split into its top and bottom 64 bits
+	top := uint64(ms)<<16 | hi
+	bottom := lo
+
+	var buf [26]byte
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = crockford[bottom&31]
+		bottom = bottom>>5 | top<<59
+		top >>= 5
+	}
+	return string(buf[:])
+}
+
+// uuidv7 encodes a timestamp and 74 random bits as an RFC 9562 version 7 UUID.
+// The first 12 random bits fill rand_a and the other 62 fill rand_b.
+func uuidv7(ms int64, hi, lo uint64) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(ms)<<16|0x7<<12|(hi<<2|lo>>62)&0xfff)
+	binary.BigEndian.PutUint64(b[8:], 0b10<<62|lo&(1<<62-1))
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,95 @@
+package uniqueids
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func TestULIDEncoding(t *testing.T) {
+	if id := ulid(0, 0, 0); id != "00000000000000000000000000" {
+		t.Errorf("expected all zeroes, got %s", id)
+	}
+	if id := ulid(1<<48-1, 1<<16-1, 1<<64-1); id != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Errorf("expected the largest ULID, got %s", id)
+	}
+
+	// the timestamp from the example in the ULID spec
+	if id := ulid(1469918176385, 0, 0); id[:10] != "01ARYZ6S41" {
+		t.Errorf("expected timestamp 01ARYZ6S41, got %s", id[:10])
+	}
+}
+
+func TestUUIDv7Encoding(t *testing.T) {
+	// the example from RFC 9562, appendix A.6
+	randA, randB := uint64(0xcc3), uint64(0x18c4dc0c0c07398f)
+	id := uuidv7(0x017f22e279b0, randA>>2, randA<<62|randB)
+
+	if expected := "017f22e2-79b0-7cc3-98c4-dc0c0c07398f"; id != expected {
+		t.Fatalf("expected %s, got %s", expected, id)
+	}
+}
+
+func TestSortableIsMonotonic(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		bits   int
+		encode func(ms int64, hi, lo uint64) string
+	}{
+		{"ulid", 80, ulid},
+		{"uuidv7", 74, uuidv7},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newSortable(tc.bits)
+			now := time.UnixMilli(1_700_000_000_000)
+			g.now = func() time.Time { return now }
+
+			var last string
+			next := func() string {
+				t.Helper()
+
+				ms, hi, lo, err := g.next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				id := tc.encode(ms, hi, lo)
+				if id <= last {
+					t.Fatalf("expected IDs to increase, got %s after %s", id, last)
+				}
+				last = id
+				return id
+			}
+
+			// many IDs in the same millisecond
+			for i := 0; i < 1000; i++ {
+				next()
+			}
+
+			// the random bits run out, and the timestamp moves on
+			g.hi, g.lo = 1<<(tc.bits-64)-1, 1<<64-1
+			next()
+			if g.last != now.UnixMilli()+1 {
+				t.Fatalf("expected the timestamp to move on, got %d", g.last)
+			}
+
+			// the clock goes backwards
+			now = now.Add(-time.Second)
+			next()
+		})
+	}
+}
+
+func TestSortableRandomFailure(t *testing.T) {
+	g := newSortable(80)
+	g.random = bytes.NewReader(nil)
+
+	if _, _, _, err := g.next(); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	g.random = rand.Reader
+	if _, _, _, err := g.next(); err != nil {
+		t.Fatal(err)
+	}
+}